@@ -16,14 +16,13 @@ var devicesCmd = &cobra.Command{
 	Use:   "devices",
 	Short: "list all of the confgured devices",
 	Run: func(cmd *cobra.Command, args []string) {
-		ih := intesishome.New(
-			flagUsername, flagPassword,
-			intesishome.WithVerbose(flagVerbose), intesishome.WithHostname(flagHTTPServer),
-		)
-		devices, err := ih.Devices()
+		ih := intesishome.New(flagUsername, flagPassword, clientOptions()...)
+		ctx, cancel := commandContext()
+		defer cancel()
+		devices, err := ih.DevicesContext(ctx)
 		if err != nil {
 			fmt.Printf("error getting devices: %v\n", err.Error())
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
 		for _, device := range devices {
 			fmt.Println(device.String())
@@ -17,22 +17,21 @@ var getCmd = &cobra.Command{
 	Short: "get the value of parameter key from the AC Unit",
 	Args:  cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
-		ih := intesishome.New(
-			flagUsername, flagPassword,
-			intesishome.WithVerbose(flagVerbose), intesishome.WithHostname(flagHTTPServer),
-		)
+		ih := intesishome.New(flagUsername, flagPassword, clientOptions()...)
 		device := toInt64(args[0])
-		state, err := ih.Status(int64(device))
+		ctx, cancel := commandContext()
+		defer cancel()
+		state, err := ih.StateContext(ctx, int64(device))
 		if err != nil {
 			fmt.Printf("error getting status: %v", err.Error())
-			os.Exit(1)
+			os.Exit(exitCode(err))
 		}
-		if _, ok := state[args[1]]; !ok {
+		value, ok := state.Values()[args[1]]
+		if !ok {
 			fmt.Printf("unable to locate status: %s", args[1])
 			os.Exit(1)
 		}
-		mappedV := intesishome.DecodeState(args[1], state[args[1]].(int))
-		fmt.Printf("(%s) %s: %v\n", args[0], args[1], mappedV)
+		fmt.Printf("(%s) %s: %v\n", args[0], args[1], value)
 	},
 }
 
@@ -17,23 +17,49 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/nullify005/service-intesis/pkg/intesishome"
 	"github.com/spf13/cobra"
 )
 
 var (
-	flagUsername   string // username for intesis cloud
-	flagPassword   string // password for intesis cloud
-	flagVerbose    bool   // debug logging
-	flagTCPServer  string // debug local emulated TCPServer
-	flagHTTPServer string // debug local emulated HTTPServer
+	flagUsername   string        // username for intesis cloud
+	flagPassword   string        // password for intesis cloud
+	flagVerbose    bool          // debug logging
+	flagTCPServer  string        // debug local emulated TCPServer
+	flagHTTPServer string        // debug local emulated HTTPServer
+	flagTimeout    time.Duration // how long to wait on the Intesis Cloud
+	flagMappings   []string      // mapping files layered over the embedded ones
+	flagTCPProxy   string        // proxy for the TCP gateway connection
+	tcpProxy       *url.URL      // the parsed flagTCPProxy
+	flagLocal      string        // a device's local API host, instead of the Intesis Cloud
+	flagWMP        string        // an IntesisBox WMP gateway, instead of the Intesis Cloud
+	flagCloud      string        // which of the Intesis clouds the account is on
+	cloud          = intesishome.CloudIntesisHome
 
 	rootCmd = &cobra.Command{
 		Use:   "service-intesis",
 		Short: "An API integration with the Intesis Cloud + Intesis Home services",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+			var ok bool
+			if cloud, ok = intesishome.LookupCloud(flagCloud); !ok {
+				return fmt.Errorf("unknown --cloud: %s wanted one of: %s", flagCloud, strings.Join(intesishome.CloudNames(), ", "))
+			}
+			if flagTCPProxy != "" {
+				if tcpProxy, err = url.Parse(flagTCPProxy); err != nil {
+					return fmt.Errorf("malformed --tcp-proxy: %w", err)
+				}
+			}
+			return nil
+		},
 	}
 )
 
@@ -51,10 +77,25 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&flagVerbose, "verbose", "v", false, "Verbosity")
 	rootCmd.PersistentFlags().StringVarP(&flagTCPServer, "tcpserver", "t", "", "use the following TCPServer host:port for HVAC control commands (DEBUG)")
 	rootCmd.PersistentFlags().StringVar(&flagHTTPServer, "httpserver", "", "use the following HTTPServer host:port for HVAC status (DEBUG)")
+	rootCmd.PersistentFlags().DurationVar(&flagTimeout, "timeout", 0, "give up on Intesis Cloud requests after this long, 0 waits indefinitely")
+	rootCmd.PersistentFlags().StringSliceVar(&flagMappings, "mappings", nil, "YAML or JSON mapping files layered over the embedded uid & command mappings")
+	rootCmd.PersistentFlags().StringVar(&flagTCPProxy, "tcp-proxy", "", "reach the TCP gateway via this proxy, http://[user:pass@]host:port or socks5://host:port")
+	rootCmd.PersistentFlags().StringVar(&flagLocal, "local", "", "talk to the device's local HTTP API at this host instead of the Intesis Cloud, username & password are the device's own")
+	rootCmd.PersistentFlags().StringVar(&flagWMP, "wmp", "", "talk to the IntesisBox gateway at this host[:port] over WMP instead of the Intesis Cloud")
+	rootCmd.PersistentFlags().StringVar(&flagCloud, "cloud", intesishome.CloudIntesisHome.Name, "the cloud the account is registered on, one of: "+strings.Join(intesishome.CloudNames(), ", "))
 	rootCmd.MarkPersistentFlagRequired("username")
 	rootCmd.MarkPersistentFlagRequired("password")
 }
 
+// the options every command's Intesis Home client is built with
+func clientOptions() []intesishome.Option {
+	return []intesishome.Option{
+		intesishome.WithVerbose(flagVerbose), intesishome.WithCloud(cloud), intesishome.WithHostname(flagHTTPServer),
+		intesishome.WithTCPServer(flagTCPServer), intesishome.WithTCPProxy(tcpProxy),
+		intesishome.WithLocal(flagLocal), intesishome.WithWMP(flagWMP), intesishome.WithMappings(flagMappings...),
+	}
+}
+
 func toInt64(s string) int64 {
 	i, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
@@ -63,3 +104,40 @@ func toInt64(s string) int64 {
 	}
 	return i
 }
+
+// exit codes which let scripts tell failures apart
+const (
+	exitFailure     int = 1
+	exitNotFound    int = 2 // the device doesn't exist
+	exitRejected    int = 3 // the Intesis Cloud refused the request
+	exitUnavailable int = 4 // a transient failure, worth trying again
+	exitInvalid     int = 5 // the command is malformed, out of range or unsupported
+	exitNotApplied  int = 6 // the unit didn't report the change as applied in time
+)
+
+// maps a failure from the Intesis Home client onto an exit code
+func exitCode(err error) int {
+	var (
+		cloudErr *intesishome.CloudError
+		localErr *intesishome.LocalError
+	)
+	switch {
+	case errors.Is(err, intesishome.ErrDeviceNotFound):
+		return exitNotFound
+	case errors.Is(err, intesishome.ErrInvalidCommand):
+		return exitInvalid
+	case errors.As(err, &cloudErr), errors.As(err, &localErr):
+		return exitRejected
+	case intesishome.Retryable(err):
+		return exitUnavailable
+	}
+	return exitFailure
+}
+
+// a context bounded by the --timeout flag
+func commandContext() (context.Context, context.CancelFunc) {
+	if flagTimeout > 0 {
+		return context.WithTimeout(context.Background(), flagTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
@@ -12,16 +12,24 @@ import (
 
 // devicesCmd represents the devices command
 var (
-	_serverTimeout *time.Duration
-	serverCmd      = &cobra.Command{
+	_serverTimeout    *time.Duration
+	_serverOldTimeout *time.Duration // the deprecated --timeout, which --read-timeout used to be
+	serverCmd         = &cobra.Command{
 		Use:   "server",
-		Short: "runs a test tcp server",
+		Short: "runs a test tcp server along with the cloud & local http servers & a wmp gateway",
 		Run: func(cmd *cobra.Command, args []string) {
+			if cmd.Flags().Changed("timeout") && !cmd.Flags().Changed("read-timeout") {
+				_serverTimeout = _serverOldTimeout
+			}
 			t := mock.NewTCPServer(
 				mock.WithTCPListen(flagTCPServer),
 				mock.WithTCPReadTimeout(*_serverTimeout),
 			)
 			go t.Run()
+			l := mock.NewLocalServer(mock.WithLocalListen(flagLocal))
+			go l.Run()
+			w := mock.NewWMPServer(mock.WithWMPListen(flagWMP))
+			go w.Run()
 			h := mock.NewHTTPServer(mock.WithHTTPListen(flagHTTPServer))
 			h.Run()
 		},
@@ -36,5 +44,8 @@ var (
 
 func init() {
 	rootCmd.AddCommand(serverCmd)
-	_serverTimeout = serverCmd.Flags().Duration("timeout", mock.DefaultReadTimeout, "read timeout duration")
+	_serverTimeout = serverCmd.Flags().Duration("read-timeout", mock.DefaultReadTimeout, "how long the mock tcp server waits on a read")
+	// shadows the global --timeout, which the server has no use for, so older invocations keep working
+	_serverOldTimeout = serverCmd.Flags().Duration("timeout", mock.DefaultReadTimeout, "how long the mock tcp server waits on a read")
+	serverCmd.Flags().MarkDeprecated("timeout", "use --read-timeout instead")
 }
@@ -4,8 +4,11 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/nullify005/service-intesis/pkg/intesishome"
 	"github.com/spf13/cobra"
@@ -14,31 +17,98 @@ import (
 // setCmd represents the set command
 var (
 	setCmd = &cobra.Command{
-		Use:   "set key value",
-		Short: "set parameter key to value on an AC Unit",
-		Args:  cobra.ExactArgs(3),
+		Use:   "set device key=value [key=value...]",
+		Short: "set parameters on an AC Unit",
+		Long: `set one or more parameters on an AC Unit, in the order given
+sending stops at the first failure. the older "set device key value" form is still accepted
+with --wait each parameter is confirmed as applied by the unit before the next is sent,
+write only ones such as resync are never reported back so they're applied once acked`,
+		Args: cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			device := toInt64(args[0])
-			key := args[1]
-			val := args[2]
-			ih := intesishome.New(
-				flagUsername, flagPassword,
-				intesishome.WithVerbose(flagVerbose), intesishome.WithHostname(flagHTTPServer),
-			)
-			// what happens when the uid isn't within the map?
-			uid, value, err := intesishome.MapCommand(key, val)
+			pairs, err := parsePairs(args[1:])
 			if err != nil {
-				fmt.Printf("encoutered error during mapping: %s\n", err.Error())
-				os.Exit(1)
+				fmt.Println(err.Error())
+				os.Exit(exitInvalid)
 			}
-			if err = ih.Set(int64(device), uid, value); err != nil {
+			ih := intesishome.New(flagUsername, flagPassword, clientOptions()...)
+			defer ih.Close()
+			ctx, cancel := commandContext()
+			defer cancel()
+			snapshot, err := ih.StatusAllContext(ctx)
+			if err != nil {
+				fmt.Printf("encountered error during status: %s\n", err.Error())
+				os.Exit(exitCode(err))
+			}
+			d, ok := snapshot.Device(device)
+			if !ok {
+				fmt.Printf("no such device: %v\n", device)
+				os.Exit(exitNotFound)
+			}
+			// map via the device's own names & reject anything the unit doesn't support
+			// before any of it goes to the gateway
+			cmds := make([]intesishome.Command, len(pairs))
+			for i, p := range pairs {
+				if cmds[i].Uid, cmds[i].Value, err = d.MapCommand(p[0], p[1]); err != nil {
+					fmt.Printf("encoutered error during mapping: %s\n", err.Error())
+					os.Exit(exitCode(err))
+				}
+			}
+			if flagWait > 0 {
+				setAndConfirm(ctx, ih, device, pairs, cmds)
+				return
+			}
+			results, err := ih.SetManyContext(ctx, device, cmds)
+			for i, r := range results {
+				status := "ok"
+				if r.Err != nil {
+					status = r.Err.Error()
+				}
+				fmt.Printf("%s=%s: %s\n", pairs[i][0], pairs[i][1], status)
+			}
+			if err != nil {
 				fmt.Printf("encountered error during set: %s\n", err.Error())
-				os.Exit(1)
+				os.Exit(exitCode(err))
 			}
 		},
 	}
 )
 
+var flagWait time.Duration
+
 func init() {
 	rootCmd.AddCommand(setCmd)
+	setCmd.Flags().DurationVarP(&flagWait, "wait", "w", 0, "wait up to this long for the unit to report each parameter as applied, 0 doesn't wait")
+}
+
+// sets & confirms each command in turn, stopping at the first which isn't applied
+func setAndConfirm(ctx context.Context, ih *intesishome.IntesisHome, device int64, pairs [][2]string, cmds []intesishome.Command) {
+	for i, c := range cmds {
+		wctx, cancel := context.WithTimeout(ctx, flagWait)
+		confirmed, err := ih.SetAndConfirmContext(wctx, device, c.Uid, c.Value)
+		cancel()
+		fmt.Printf("%s=%s: %s\n", pairs[i][0], pairs[i][1], confirmed)
+		if err != nil {
+			fmt.Printf("encountered error during set: %s\n", err.Error())
+			os.Exit(exitCode(err))
+		}
+		if confirmed != intesishome.ConfirmApplied {
+			os.Exit(exitNotApplied)
+		}
+	}
+}
+
+// splits key=value arguments, a lone key value pair without the = is also accepted
+func parsePairs(args []string) (pairs [][2]string, err error) {
+	if len(args) == 2 && !strings.Contains(args[0], "=") {
+		return [][2]string{{args[0], args[1]}}, nil
+	}
+	for _, a := range args {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value got: %s", a)
+		}
+		pairs = append(pairs, [2]string{k, v})
+	}
+	return
 }
@@ -14,32 +14,49 @@ import (
 
 // statusCmd represents the status command
 var statusCmd = &cobra.Command{
-	Use:   "status device",
-	Short: "fetch the current status of an AC Unit",
-	Args:  cobra.ExactArgs(1),
+	Use:   "status [device]",
+	Short: "fetch the current status of an AC Unit, or of every AC Unit",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		ih := intesishome.New(
-			flagUsername, flagPassword,
-			intesishome.WithVerbose(flagVerbose), intesishome.WithHostname(flagHTTPServer),
-		)
+		ih := intesishome.New(flagUsername, flagPassword, clientOptions()...)
+		ctx, cancel := commandContext()
+		defer cancel()
+		if len(args) == 0 {
+			// every device from a single call
+			snapshot, err := ih.StatusAllContext(ctx)
+			if err != nil {
+				fmt.Printf("encountered error fetching status: %v\n", err.Error())
+				os.Exit(exitCode(err))
+			}
+			for _, d := range snapshot.List() {
+				fmt.Printf("(%s) %s / %s\n", d.Device.ID, d.Installation.Name, d.Device.Name)
+				printState(d.State, "  ")
+			}
+			return
+		}
 		device := toInt64(args[0])
-		state, err := ih.Status(int64(device))
+		state, err := ih.StateContext(ctx, int64(device))
 		if err != nil {
 			fmt.Printf("encountered error fetching status: %v\n", err.Error())
-			os.Exit(1)
-		}
-		keys := make([]string, 0)
-		for k := range state {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-		for _, k := range keys {
-			mappedV := intesishome.DecodeState(k, state[k].(int))
-			fmt.Printf("%v: %v\n", k, mappedV)
+			os.Exit(exitCode(err))
 		}
+		printState(state, "")
 	},
 }
 
+// prints the decoded state sorted by name
+func printState(state intesishome.DeviceState, indent string) {
+	values := state.Values()
+	keys := make([]string, 0)
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s%v: %v\n", indent, k, values[k])
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 }
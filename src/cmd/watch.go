@@ -16,9 +16,9 @@ var (
 	_flagListen   *string
 	_flagSecrets  *string
 	watchCmd      = &cobra.Command{
-		Use:   "watch [-i time.Duration] [-l host:port] device",
-		Short: "watch an AC Units state and expose it to prometheus scraping",
-		Args:  cobra.ExactArgs(1),
+		Use:   "watch [-i time.Duration] [-l host:port] [device...]",
+		Short: "watch the state of every AC Unit, or only those given, and expose it to prometheus scraping",
+		Args:  cobra.ArbitraryArgs,
 		PreRun: func(cmd *cobra.Command, args []string) {
 			flags := cmd.InheritedFlags()
 			// disable parent required flags since we're hoping to use a secrets file
@@ -26,15 +26,19 @@ var (
 			flags.SetAnnotation("password", cobra.BashCompOneRequiredFlag, []string{"false"})
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			device := toInt64(args[0])
+			devices := make([]int64, len(args))
+			for i, a := range args {
+				devices[i] = toInt64(a)
+			}
 			w := watcher.New(
 				flagUsername, flagPassword,
-				int64(device),
+				watcher.WithDevices(devices...),
 				watcher.WithSecrets(*_flagSecrets),
 				watcher.WithDuration(*_flagInterval),
 				watcher.WithListen(*_flagListen),
 				watcher.WithVerbose(flagVerbose),
 				watcher.WithHostname(flagHTTPServer),
+				watcher.WithClientOptions(clientOptions()...),
 			)
 			w.Watch()
 		},
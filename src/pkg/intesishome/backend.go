@@ -0,0 +1,50 @@
+package intesishome
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoPush = errors.New("pushed state isn't available via this backend")
+
+// reaches the devices some other way than the Intesis Cloud & its TCP gateway, such as their
+// local API. everything else the client does is built on the control response & sets it provides
+type backend interface {
+	gateway
+	control(ctx context.Context) (ControlResponse, error)
+	close()
+}
+
+// a backend which pushes changes as they happen, as the TCP gateway does
+type pusher interface {
+	// connects if need be, returning a channel closed once the connection drops
+	connected(ctx context.Context) (<-chan struct{}, error)
+}
+
+// sends sets to a device, either the TCP gateway session or a backend
+type gateway interface {
+	set(ctx context.Context, device int64, uid, value int) (Ack, error)
+}
+
+// the backend if there is one, otherwise the TCP gateway session
+func (ih *IntesisHome) gateway(ctx context.Context) (gateway, error) {
+	if ih.backend != nil {
+		return ih.backend, nil
+	}
+	return ih.session(ctx)
+}
+
+// connects whatever pushes state changes, returning a channel closed once the connection drops
+func (ih *IntesisHome) pushes(ctx context.Context) (<-chan struct{}, error) {
+	if ih.backend == nil {
+		s, err := ih.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return s.done, nil
+	}
+	if p, ok := ih.backend.(pusher); ok {
+		return p.connected(ctx)
+	}
+	return nil, errNoPush
+}
@@ -0,0 +1,89 @@
+package intesishome
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+//go:embed "assets/clouds"
+var _embeddedClouds embed.FS
+
+// where the clouds' overlays are read from
+var _cloudMappings fs.FS = _embeddedClouds
+
+// one of the Intesis clouds, they share the API but differ in hostname, API version & some uids
+type Cloud struct {
+	Name     string
+	Hostname string
+	Version  string // sent along with every control request
+	mappings string // the overlay for the cloud's uids within assets/clouds, if it has one
+}
+
+// the clouds pyIntesisHome knows of
+var (
+	CloudIntesisHome  = Cloud{Name: "intesishome", Hostname: DefaultHostname, Version: "1.8.5"}
+	CloudAirconwithme = Cloud{Name: "airconwithme", Hostname: "https://user.airconwithme.com", Version: "1.6.2", mappings: "airconwithme.yaml"}
+	CloudAnywAir      = Cloud{Name: "anywair", Hostname: "https://anywair.intesishome.com", Version: "2.9", mappings: "anywair.yaml"}
+
+	_clouds = []Cloud{CloudIntesisHome, CloudAirconwithme, CloudAnywAir}
+)
+
+// looks up a cloud by its name, ignoring case
+func LookupCloud(name string) (c Cloud, ok bool) {
+	for _, c = range _clouds {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+	return Cloud{}, false
+}
+
+// the names of every cloud
+func CloudNames() (names []string) {
+	for _, c := range _clouds {
+		names = append(names, c.Name)
+	}
+	return
+}
+
+// talks to the given cloud, its uids are layered over a copy of the client's registry
+// ahead of any WithMappings, so clients on other clouds are unaffected. an explicit
+// WithHostname still wins
+func WithCloud(c Cloud) Option {
+	return func(ih *IntesisHome) {
+		ih.cloud = c
+	}
+}
+
+// layers the cloud's own uids over the registry, once. call it ahead of LoadFile
+// so that user supplied mappings win over the cloud's
+func (r *Registry) LoadCloud(c Cloud) error {
+	r.mu.RLock()
+	loaded := r.clouds[c.Name]
+	r.mu.RUnlock()
+	if c.mappings == "" || loaded {
+		return nil
+	}
+	data, err := fs.ReadFile(_cloudMappings, path.Join("assets/clouds", c.mappings))
+	if err != nil {
+		return err
+	}
+	if err = r.Load(data); err != nil {
+		return fmt.Errorf("%s: %w", c.mappings, err)
+	}
+	r.mu.Lock()
+	r.clouds[c.Name] = true
+	r.mu.Unlock()
+	return nil
+}
+
+// where the control endpoint lives, the cloud's unless overridden
+func (ih *IntesisHome) baseURL() string {
+	if ih.hostname != "" {
+		return ih.hostname
+	}
+	return ih.cloud.Hostname
+}
@@ -0,0 +1,85 @@
+package intesishome
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloud(t *testing.T) {
+	body, err := os.ReadFile(testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("unable to read the payload: %v", err)
+	}
+	// records the version each control request was made with
+	var versions []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.FormValue("version"))
+		w.Write(body)
+	}))
+	defer s.Close()
+
+	t.Run("lookup", func(t *testing.T) {
+		c, ok := LookupCloud("AirconWithMe")
+		assert.True(t, ok)
+		assert.Equal(t, CloudAirconwithme, c)
+		_, ok = LookupCloud("nope")
+		assert.False(t, ok)
+		assert.Equal(t, []string{"intesishome", "airconwithme", "anywair"}, CloudNames())
+	})
+	t.Run("version per cloud", func(t *testing.T) {
+		versions = nil
+		for _, c := range []Cloud{CloudIntesisHome, CloudAirconwithme, CloudAnywAir} {
+			ih := New("u", "p", WithCloud(c), WithHostname(s.URL))
+			_, err := ih.StatusAll()
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, []string{"1.8.5", "1.6.2", "2.9"}, versions)
+	})
+	t.Run("hostname per cloud", func(t *testing.T) {
+		assert.Equal(t, DefaultHostname, New("u", "p").baseURL())
+		assert.Equal(t, "https://user.airconwithme.com", New("u", "p", WithCloud(CloudAirconwithme)).baseURL())
+		assert.Equal(t, "https://user.airconwithme.com", New("u", "p", WithHostname(""), WithCloud(CloudAirconwithme)).baseURL())
+		assert.Equal(t, s.URL, New("u", "p", WithHostname(s.URL), WithCloud(CloudAnywAir)).baseURL(), "an explicit hostname wins")
+	})
+}
+
+func TestLoadCloud(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.LoadCloud(CloudAirconwithme))
+	assert.True(t, r.clouds[CloudAirconwithme.Name])
+	assert.NoError(t, r.LoadCloud(CloudIntesisHome), "the default cloud has nothing to layer")
+	assert.False(t, r.clouds[CloudIntesisHome.Name])
+}
+
+func TestCloudMappings(t *testing.T) {
+	t.Cleanup(func() { _cloudMappings = _embeddedClouds })
+	_cloudMappings = fstest.MapFS{
+		"assets/clouds/night.yaml": {Data: []byte(`{"state": {"200": {"name": "night_mode", "values": {"0": "off", "1": "on"}}}}`)},
+	}
+	night := Cloud{Name: "night", Hostname: "https://night.example.com", Version: "1", mappings: "night.yaml"}
+
+	t.Run("per client", func(t *testing.T) {
+		ih := New("u", "p", WithCloud(night))
+		assert.Equal(t, "night_mode", ih.Registry().DecodeUid(200))
+		assert.Equal(t, "on", ih.Registry().DecodeState("night_mode", 1))
+		assert.Equal(t, "200", New("u", "p", WithCloud(CloudIntesisHome)).Registry().DecodeUid(200))
+		assert.Equal(t, "200", DefaultRegistry.DecodeUid(200), "DefaultRegistry is untouched")
+	})
+	t.Run("mapping files win over the cloud's", func(t *testing.T) {
+		f := t.TempDir() + "/quiet.yaml"
+		assert.NoError(t, os.WriteFile(f, []byte(`{"state": {"200": {"name": "quiet_mode"}}}`), 0o600))
+		assert.Equal(t, "quiet_mode", New("u", "p", WithCloud(night), WithMappings(f)).Registry().DecodeUid(200))
+		assert.Equal(t, "quiet_mode", New("u", "p", WithMappings(f), WithCloud(night)).Registry().DecodeUid(200))
+	})
+	t.Run("an overlay which doesn't load fails every call", func(t *testing.T) {
+		broken := night
+		broken.mappings = "nonexistent.yaml"
+		_, err := New("u", "p", WithCloud(broken)).StatusAll()
+		assert.ErrorContains(t, err, "unable to load the night mappings")
+	})
+}
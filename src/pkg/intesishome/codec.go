@@ -0,0 +1,57 @@
+package intesishome
+
+import (
+	"fmt"
+	"math"
+)
+
+// how the raw value of a uid converts to & from engineering units
+// declared alongside the uid within the state map
+type Codec struct {
+	Scale       float64 `json:"scale" yaml:"scale"`             // raw values are the engineering value multiplied by this, 0 is treated as 1
+	Signed      bool    `json:"signed" yaml:"signed"`           // raw values are two's complement 16 bit
+	Unit        string  `json:"unit" yaml:"unit"`               // the engineering unit, such as celsius
+	Unavailable []int   `json:"unavailable" yaml:"unavailable"` // raw values which mean the device can't report the value
+}
+
+// whether the uid has anything to convert
+func (c Codec) numeric() bool {
+	return c.Scale != 0 || c.Signed || c.Unit != "" || len(c.Unavailable) > 0
+}
+
+// converts a raw value into engineering units, ok is false when the raw value means unavailable
+func (c Codec) Decode(raw int) (v float64, ok bool) {
+	for _, u := range c.Unavailable {
+		if raw == u {
+			return
+		}
+	}
+	if c.Signed && raw >= 1<<15 && raw < 1<<16 {
+		raw -= 1 << 16
+	}
+	v = float64(raw)
+	if c.Scale != 0 {
+		v /= c.Scale
+	}
+	return v, true
+}
+
+// converts a value in engineering units into the raw value sent to the device
+func (c Codec) Encode(v float64) (raw int, err error) {
+	if c.Scale != 0 {
+		v *= c.Scale
+	}
+	raw = int(math.Round(v))
+	switch {
+	case c.Signed && (raw < -(1<<15) || raw >= 1<<15):
+		err = fmt.Errorf("value out of range for a signed 16 bit uid: %v", v)
+	case c.Signed && raw < 0:
+		raw += 1 << 16
+	}
+	return
+}
+
+// the codec declared for the uid within DefaultRegistry
+func LookupCodec(uid int) Codec {
+	return DefaultRegistry.Codec(uid)
+}
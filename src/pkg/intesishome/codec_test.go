@@ -0,0 +1,61 @@
+package intesishome
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	tenths := LookupCodec(37 /* outdoor_temp */)
+	assert.Equal(t, Codec{Scale: 10, Signed: true, Unit: "celsius", Unavailable: []int{32768}}, tenths)
+	assert.Equal(t, Codec{}, LookupCodec(1 /* power */))
+	assert.Equal(t, Codec{}, LookupCodec(65535))
+
+	decodes := []struct {
+		name  string
+		codec Codec
+		raw   int
+		want  float64
+		ok    bool
+	}{
+		{"scaled", tenths, 215, 21.5, true},
+		{"two's complement", tenths, 65526, -1.0, true},
+		{"already negative", tenths, -15, -1.5, true},
+		{"sentinel", tenths, 32768, 0, false},
+		{"unsigned", Codec{Unit: "hours"}, 65526, 65526, true},
+		{"passthrough", Codec{}, 7, 7, true},
+	}
+	for _, tt := range decodes {
+		t.Run("decode "+tt.name, func(t *testing.T) {
+			v, ok := tt.codec.Decode(tt.raw)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, v)
+		})
+	}
+
+	encodes := []struct {
+		name  string
+		codec Codec
+		value float64
+		want  int
+		err   bool
+	}{
+		{"scaled", tenths, 21.5, 215, false},
+		{"rounded", tenths, 21.04, 210, false},
+		{"two's complement", tenths, -1, 65526, false},
+		{"out of range", tenths, 4000, 0, true},
+		{"passthrough", Codec{}, 3, 3, false},
+	}
+	for _, tt := range encodes {
+		t.Run("encode "+tt.name, func(t *testing.T) {
+			raw, err := tt.codec.Encode(tt.value)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, raw)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package intesishome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultConfirmInterval time.Duration = 5 * time.Second // how often the status is polled whilst confirming a set
+)
+
+// whether a set took effect on the unit
+type Confirmation int
+
+const (
+	ConfirmApplied    Confirmation = iota // the device reports the requested value
+	ConfirmNotApplied                     // the device was still reporting another value when the deadline passed
+	ConfirmTimeout                        // the deadline passed without the device reporting the uid
+)
+
+func (c Confirmation) String() string {
+	switch c {
+	case ConfirmApplied:
+		return "applied"
+	case ConfirmNotApplied:
+		return "not-applied"
+	case ConfirmTimeout:
+		return "timeout"
+	}
+	return fmt.Sprint(int(c))
+}
+
+// overrides how often the status is polled whilst confirming a set
+func WithConfirmInterval(d time.Duration) Option {
+	return func(ih *IntesisHome) {
+		ih.pollEvery = d
+	}
+}
+
+// sets the uid to the raw value & waits up to timeout for the device to report it
+// see SetAndConfirmContext
+func (ih *IntesisHome) SetAndConfirm(device int64, uid, value int, timeout time.Duration) (Confirmation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return ih.SetAndConfirmContext(ctx, device, uid, value)
+}
+
+// sets the uid to the raw value & waits until the device reports it or ctx is done.
+// a set_ack only means the gateway has the command, the unit may still refuse it or take a while,
+// so the status is polled & pushed changes are watched until the uid shows the value.
+// write only commands, such as resync, are never reported back so they're applied once acked.
+// err is only set when the set itself failed, ctx being done is reported as the Confirmation
+func (ih *IntesisHome) SetAndConfirmContext(ctx context.Context, device int64, uid, value int) (Confirmation, error) {
+	// subscribe before setting so a push straight after the ack isn't missed,
+	// polling alone will do should the subscription fail
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := ih.Subscribe(subCtx)
+	if err != nil && ih.verbose {
+		fmt.Printf("DEBUG|confirm| unable to subscribe, polling only: %v\n", err)
+	}
+	if err = ih.SetContext(ctx, device, uid, value); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return ConfirmTimeout, err
+		}
+		return ConfirmNotApplied, err
+	}
+	if ih.registry.WriteOnly(uid) {
+		return ConfirmApplied, nil
+	}
+
+	name := ih.registry.DecodeUid(uid)
+	observed := false // whether the device has reported the uid since the set
+	poll := time.NewTimer(0)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if observed {
+				return ConfirmNotApplied, nil
+			}
+			return ConfirmTimeout, nil
+		case ev, ok := <-events:
+			if !ok {
+				// the subscription ended, keep on polling
+				events = nil
+				continue
+			}
+			if ev.DeviceID != device || ev.Uid != uid {
+				continue
+			}
+			if ev.Value == value {
+				return ConfirmApplied, nil
+			}
+			observed = true
+		case <-poll.C:
+			snapshot, err := ih.StatusAllContext(ctx)
+			if err == nil {
+				if d, ok := snapshot.Device(device); ok {
+					if v, ok := d.State.Raw[name]; ok {
+						if v == value {
+							return ConfirmApplied, nil
+						}
+						observed = true
+					}
+				}
+			} else if ih.verbose {
+				fmt.Printf("DEBUG|confirm| unable to poll the status: %v\n", err)
+			}
+			poll.Reset(ih.pollEvery)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package intesishome
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetAndConfirm(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	h, err := mockHTTPServer(200, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer h.Close()
+
+	// acks the set & pushes the setpoint change straight after
+	pushingSetpoint := func(t *testing.T, req string) []string {
+		replies := okGateway(t, req)
+		if isSet(t, req) {
+			replies = append(replies, fmt.Sprintf(_testStatusPushTmpl, 9, 215))
+		}
+		return replies
+	}
+
+	tests := []struct {
+		name    string
+		gateway gatewayReplyFunc
+		uid     int
+		value   int
+		want    Confirmation
+	}{
+		{"applied per the status", okGateway, 1, 1, ConfirmApplied},
+		{"applied per a push", pushingSetpoint, 9, 215, ConfirmApplied},
+		{"status shows another value", okGateway, 9, 215, ConfirmNotApplied},
+		{"uid never reported", okGateway, 12345, 1, ConfirmTimeout},
+		{"write only resync once acked", okGateway, 143, 1, ConfirmApplied},
+		{"write only error reset once acked", okGateway, 54, 1, ConfirmApplied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := testGatewayListener(t, tt.gateway)
+			ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0), WithConfirmInterval(50*time.Millisecond))
+			defer ih.Close()
+			got, err := ih.SetAndConfirm(d, tt.uid, tt.value, 300*time.Millisecond)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got, "got: %v", got)
+		})
+	}
+	t.Run("write only without waiting", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, okGateway)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0))
+		defer ih.Close()
+		snapshot, err := ih.StatusAll()
+		assert.NoError(t, err)
+		dev, _ := snapshot.Device(d)
+		uid, v, err := dev.MapCommand("resync", "on")
+		assert.NoError(t, err)
+		results, err := ih.SetMany(d, []Command{{Uid: uid, Value: v}})
+		assert.NoError(t, err)
+		assert.NoError(t, results[0].Err)
+	})
+	t.Run("set fails", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, func(t *testing.T, req string) []string {
+			if isSet(t, req) {
+				return []string{ackFor(t, req, _testSetInvalid)}
+			}
+			return okGateway(t, req)
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0), WithRetryPolicy(_testRetryPolicy))
+		defer ih.Close()
+		got, err := ih.SetAndConfirm(d, 1, 1, time.Second)
+		assert.ErrorIs(t, err, ErrProtocol)
+		assert.Equal(t, ConfirmNotApplied, got)
+	})
+}
+
+func TestConfirmationString(t *testing.T) {
+	assert.Equal(t, "applied", ConfirmApplied.String())
+	assert.Equal(t, "not-applied", ConfirmNotApplied.String())
+	assert.Equal(t, "timeout", ConfirmTimeout.String())
+}
@@ -0,0 +1,87 @@
+package intesishome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// the categories of failure which callers can match on via errors.Is
+var (
+	ErrTokenRejected  = errors.New("token rejected")      // the gateway answered connect_req with err_token
+	ErrDeviceNotFound = errors.New("device not found")    // the device isn't configured within Intesis Home
+	ErrUnreachable    = errors.New("gateway unreachable") // the cloud or TCP gateway couldn't be reached or hung up
+	ErrProtocol       = errors.New("protocol violation")  // a reply was malformed or not what was expected
+	ErrTimeout        = errors.New("timed out")           // a reply didn't arrive in time
+	ErrInvalidCommand = errors.New("invalid command")     // the command is malformed, out of range or unsupported by the device
+	ErrNotSent        = errors.New("not sent")            // an earlier command within the batch failed so this one wasn't sent
+)
+
+// a failure of the given kind, one of the Err sentinels, along with its cause
+// errors.Is matches both the kind & anything within the cause
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// an error reported by the Intesis Cloud within the control response
+type CloudError struct {
+	Code    int
+	Message string
+}
+
+func (e *CloudError) Error() string {
+	return fmt.Sprintf("unexpected response error: %v message: %v", e.Code, e.Message)
+}
+
+// the control endpoint answered with something other than a 200
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected response code: %v body: %s", e.StatusCode, e.Body)
+}
+
+// server side failures are treated as the cloud being unreachable
+func (e *HTTPError) Is(target error) bool {
+	return target == ErrUnreachable && e.StatusCode >= 500
+}
+
+// builds an Error of kind from a formatted cause
+func newError(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+// categorises a network or context failure
+func networkError(err error) error {
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return &Error{Kind: ErrTimeout, Err: err}
+	}
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &Error{Kind: ErrUnreachable, Err: err}
+}
+
+// whether the failure is transient, so the same request may succeed if tried again
+func Retryable(err error) bool {
+	return errors.Is(err, ErrUnreachable) ||
+		errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrTokenRejected)
+}
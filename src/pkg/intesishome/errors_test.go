@@ -0,0 +1,45 @@
+package intesishome
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		kind      error
+		retryable bool
+	}{
+		{"token rejected", newError(ErrTokenRejected, "err_token"), ErrTokenRejected, true},
+		{"unreachable", networkError(errors.New("connection refused")), ErrUnreachable, true},
+		{"deadline", networkError(context.DeadlineExceeded), ErrTimeout, true},
+		{"protocol", newError(ErrProtocol, "garbage"), ErrProtocol, false},
+		{"device not found", newError(ErrDeviceNotFound, "12345"), ErrDeviceNotFound, false},
+		{"server error", &HTTPError{StatusCode: http.StatusBadGateway}, ErrUnreachable, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.err, tt.kind)
+			assert.Equal(t, tt.retryable, Retryable(tt.err))
+		})
+	}
+	t.Run("cancellation is left alone", func(t *testing.T) {
+		err := networkError(context.Canceled)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.False(t, Retryable(err))
+	})
+	t.Run("client errors aren't retried", func(t *testing.T) {
+		err := &HTTPError{StatusCode: http.StatusNotFound}
+		assert.NotErrorIs(t, err, ErrUnreachable)
+		assert.False(t, Retryable(err))
+	})
+	t.Run("cloud errors aren't retried", func(t *testing.T) {
+		assert.False(t, Retryable(&CloudError{Code: 1, Message: "WRONG_METHOD"}))
+	})
+}
@@ -1,9 +1,10 @@
 package intesishome
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -21,13 +22,34 @@ const (
 type IntesisHome struct {
 	username   string
 	password   string
-	hostname   string
+	hostname   string // overrides the cloud's hostname when set
+	cloud      Cloud
 	serverIP   string
 	serverPort int
 	tcpServer  string
 	token      int
 	verbose    bool
-	cmdSocket  net.Conn // holder for the tcpSocket
+	keepalive  time.Duration
+	client     *http.Client      // calls the Intesis Cloud
+	transport  http.RoundTripper // replaces the client's own transport when set
+	dialer     Dialer            // dials the TCP gateway
+	backend    backend           // used instead of the Intesis Cloud when set
+	pollEvery  time.Duration     // how often the status is polled whilst confirming a set
+	observer   Observer          // told about requests, sets & token refreshes when set
+	registry   *Registry         // the uid & command mappings
+	mappings   []string          // mapping files layered over a copy of the registry
+	err        error             // why the client couldn't be built, returned by every call
+	retry      RetryPolicy
+	setDelay   time.Duration   // the pause between commands, as asked for by the cloud
+	fixedDelay bool            // whether setDelay was set via WithSetDelay
+	last       ControlResponse // the previous control response, for conditional polling
+	sess       *session        // the shared TCP gateway session
+	sessMu     sync.Mutex
+	subs       map[chan StatusEvent]context.CancelFunc // status push subscribers
+	subMu      sync.Mutex
+	seqNo      int64         // the last set sequence number, accessed atomically
+	queue      *commandQueue // serialises commands to the gateway
+	queueMu    sync.Mutex
 	mu         sync.Mutex
 }
 
@@ -35,22 +57,72 @@ type Option func(c *IntesisHome)
 
 func New(user, pass string, opts ...Option) *IntesisHome {
 	c := IntesisHome{
-		username: user,
-		password: pass,
-		hostname: DefaultHostname,
-		verbose:  false,
+		username:  user,
+		password:  pass,
+		cloud:     CloudIntesisHome,
+		verbose:   false,
+		keepalive: DefaultKeepalive,
+		client:    http.DefaultClient,
+		dialer:    &net.Dialer{Timeout: _socketReadTimeout},
+		pollEvery: DefaultConfirmInterval,
+		retry:     DefaultRetryPolicy,
+		registry:  DefaultRegistry,
 	}
 	for _, opt := range opts {
 		opt(&c)
 	}
+	if c.transport != nil {
+		// once every option has run, so it applies whichever HTTP client was given
+		hc := *c.client
+		hc.Transport = c.transport
+		c.client = &hc
+	}
+	if c.cloud.mappings != "" || len(c.mappings) > 0 {
+		c.err = c.layerMappings()
+	}
 	return &c
 }
 
-// set an alternate hostname for API calls, useful for testing
+// layers the cloud's uids & then the mapping files over a copy of the registry, so
+// that the files win over the cloud & nothing else sees either
+func (ih *IntesisHome) layerMappings() error {
+	r := ih.registry.Clone()
+	if err := r.LoadCloud(ih.cloud); err != nil {
+		return fmt.Errorf("unable to load the %s mappings: %w", ih.cloud.Name, err)
+	}
+	for _, p := range ih.mappings {
+		if err := r.LoadFile(p); err != nil {
+			return fmt.Errorf("unable to load mappings: %w", err)
+		}
+	}
+	ih.registry = r
+	return nil
+}
+
+// the uid & command mappings the client decodes & maps commands with, DefaultRegistry unless given
+func WithRegistry(r *Registry) Option {
+	return func(ih *IntesisHome) {
+		ih.registry = r
+	}
+}
+
+// mapping files layered over a copy of the client's registry, so nothing else sees them
+// a file which doesn't load fails every call the client makes
+func WithMappings(paths ...string) Option {
+	return func(ih *IntesisHome) {
+		ih.mappings = append(ih.mappings, paths...)
+	}
+}
+
+// the uid & command mappings the client uses
+func (ih *IntesisHome) Registry() *Registry {
+	return ih.registry
+}
+
+// set an alternate hostname for API calls, useful for testing. empty is the cloud's own
 func WithHostname(host string) Option {
 	return func(ih *IntesisHome) {
-		// set an appropriate default
-		ih.hostname = DefaultHostname
+		ih.hostname = ""
 		if host == "" {
 			return
 		}
@@ -76,140 +148,121 @@ func WithTCPServer(addr string) Option {
 	}
 }
 
+// how often to poke the TCP gateway to keep the session open, 0 disables it
+func WithKeepalive(d time.Duration) Option {
+	return func(ih *IntesisHome) {
+		ih.keepalive = d
+	}
+}
+
 // lists the devices confgured within Intesis Home
 func (ih *IntesisHome) Devices() (devices []Device, err error) {
-	response, err := controlRequest(ih)
+	return ih.DevicesContext(context.Background())
+}
+
+// lists the devices confgured within Intesis Home, giving up once ctx is done
+func (ih *IntesisHome) DevicesContext(ctx context.Context) (devices []Device, err error) {
+	snapshot, err := ih.StatusAllContext(ctx)
 	if err != nil {
 		return
 	}
-	for _, inst := range response.Config.Inst {
-		devices = append(devices, inst.Devices...)
+	for _, d := range snapshot.List() {
+		devices = append(devices, d.Device)
 	}
 	return
 }
 
 // checks to see whether a device is one that Intesis Home knows about
 func (ih *IntesisHome) HasDevice(d int64) (found bool, err error) {
-	found = false
-	devices, err := ih.Devices()
+	return ih.HasDeviceContext(context.Background(), d)
+}
+
+// checks to see whether a device is one that Intesis Home knows about, giving up once ctx is done
+func (ih *IntesisHome) HasDeviceContext(ctx context.Context, d int64) (found bool, err error) {
+	snapshot, err := ih.StatusAllContext(ctx)
 	if err != nil {
 		return
 	}
-	for _, dev := range devices {
-		if fmt.Sprint(d) == dev.ID {
-			found = true
-			break
-		}
-	}
+	_, found = snapshot.Device(d)
 	return
 }
 
 // performs a change on a device using a uid & value
 // mappings for parameter names to values should be conducted via MapCommand
-// we reset & establish the connect here in order to have a single place
-// to catch & reset any connection issues with the socket
-// TODO: should there actually be some retries here?
+// the command waits its turn behind any others, is sent over the shared session, which
+// is (re)established on demand & transient failures are retried according to the RetryPolicy
 func (ih *IntesisHome) Set(device int64, uid, value int) (err error) {
-	// force a refresh of the token
-	if _, err = controlRequest(ih); err != nil {
-		return
-	}
+	return ih.SetContext(context.Background(), device, uid, value)
+}
 
-	if ih.cmdSocket != nil {
-		ih.cmdSocket.Close()
-		ih.cmdSocket = nil
+// performs a change on a device using a uid & value, giving up once ctx is done
+// ctx covers refreshing the token, establishing the session & waiting on the ack
+// a set abandoned part way through leaves the session in an unknown state so it's closed
+// ctx also covers the time spent queued behind other commands
+func (ih *IntesisHome) SetContext(ctx context.Context, device int64, uid, value int) (err error) {
+	if ih.err != nil {
+		return ih.err
 	}
-	ih.cmdSocket, err = net.Dial("tcp", fmt.Sprintf("%s:%v", ih.serverIP, ih.serverPort))
-	if err != nil {
-		return
-	}
-
-	ih.cmdSocket.SetDeadline(time.Now().Add(_socketReadTimeout))
-	err = setHandler(ih, device, uid, value)
-	ih.cmdSocket.Close()
-	ih.cmdSocket = nil
-	return
+	return ih.commands().submit(ctx, func(ctx context.Context) error {
+		return ih.retry.do(ctx, ih.verbose, func() error {
+			// a failed attempt leaves the session closed, so the next one gets a fresh token
+			g, err := ih.gateway(ctx)
+			if err != nil {
+				return err
+			}
+			_, err = g.set(ctx, device, uid, value)
+			return err
+		})
+	})
 }
 
-// the inner handler for Set
-// expects that the tcp socket has been reset / cleaned for work
-func setHandler(ih *IntesisHome, device int64, uid, value int) (err error) {
-	var cmdResp CommandResponse
-
-	// authenticate
-	cmd := &CommandRequest{
-		Command: commandReqCon,
-		Data: CommandRequestData{
-			Token: ih.token,
-		},
-	}
-	bytes, err := json.Marshal(cmd)
-	if err != nil {
-		e := fmt.Errorf("auth command encode error. auth: %v cause: %v", cmd, err)
-		return e
+// obtains the control response, retrying according to the RetryPolicy
+func (ih *IntesisHome) control(ctx context.Context) (r ControlResponse, err error) {
+	if ih.err != nil {
+		return r, ih.err
 	}
-	resp, err := socketWriteRead(ih, bytes)
-	if err != nil {
-		e := fmt.Errorf("auth write error. auth: %v cause: %v", cmd, err)
-		return e
-	}
-	ih.token = 0 // consume the token
-	if err = json.Unmarshal(resp, &cmdResp); err != nil {
-		e := fmt.Errorf("auth response decode error. resp: %s caused: %v", string(resp), err)
-		return e
-	}
-	if cmdResp.Command != commandRspCon {
-		err = fmt.Errorf("unexpected auth reply. expected: %s got: %s", commandRspCon, cmdResp.Command)
-		return
-	}
-	if cmdResp.Data.Status != commandAuthOk {
-		err = fmt.Errorf("unexpected auth reply. expected: %s got: %s", commandAuthOk, cmdResp.Data.Status)
+	err = ih.retry.do(ctx, ih.verbose, func() (err error) {
+		if ih.backend != nil {
+			r, err = ih.backend.control(ctx)
+			return
+		}
+		r, err = controlRequest(ctx, ih, false)
 		return
-	}
+	})
+	return
+}
 
-	// now write the command
-	cmd = &CommandRequest{
-		Command: commandReqSet,
-		Data: CommandRequestData{
-			DeviceID: device,
-			Uid:      uid,
-			Value:    value,
-			SeqNo:    0,
-		},
-	}
-	bytes, err = json.Marshal(cmd)
-	if err != nil {
-		e := fmt.Errorf("set command encode error. cmd: %v cause: %v", cmd, err)
-		return e
-	}
-	resp, err = socketWriteRead(ih, bytes)
-	if err != nil {
-		e := fmt.Errorf("set command write error. cmd: %v cause: %v", cmd, err)
-		return e
+// ends any subscriptions & closes the TCP gateway session if there is one
+func (ih *IntesisHome) Close() {
+	ih.unsubscribeAll()
+	ih.stopCommands()
+	if ih.backend != nil {
+		ih.backend.close()
 	}
-	if err = json.Unmarshal(resp, &cmdResp); err != nil {
-		e := fmt.Errorf("set command response error. cmd: %v response: %s cause: %v", cmd, string(resp), err)
-		return e
+	ih.sessMu.Lock()
+	defer ih.sessMu.Unlock()
+	if ih.sess != nil {
+		ih.sess.close()
+		ih.sess = nil
 	}
-	if cmdResp.Command != commandRspSet {
-		err = fmt.Errorf("set command failed. cmd: %v expected: %s got: %s", cmd, commandRspSet, cmdResp.Command)
-		return
-	}
-	return
 }
 
 // contacts the Intesis Home API to obtain the status of a device
 func (ih *IntesisHome) Status(device int64) (state map[string]interface{}, err error) {
-	state = make(map[string]interface{})
-	response, err := controlRequest(ih)
+	return ih.StatusContext(context.Background(), device)
+}
+
+// contacts the Intesis Home API to obtain the status of a device, giving up once ctx is done
+func (ih *IntesisHome) StatusContext(ctx context.Context, device int64) (state map[string]interface{}, err error) {
+	snapshot, err := ih.StatusAllContext(ctx)
 	if err != nil {
 		return
 	}
-	for _, s := range response.Status.Status {
-		if s.DeviceID != device {
-			continue
-		}
-		state[DecodeUid(s.UID)] = s.Value
+	d, ok := snapshot.Device(device)
+	if !ok {
+		err = newError(ErrDeviceNotFound, "device not found: %v", device)
+		return
 	}
+	state = d.Status
 	return
 }
@@ -1,11 +1,13 @@
 package intesishome
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -19,15 +21,17 @@ const (
 )
 
 var (
+	// retries without holding up the tests
+	_testRetryPolicy       = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Retryable: Retryable}
 	_testStateVerifyMapped = map[string]interface{}{
 		"alarm_status": 0,
 		"mode":         "heat",
 		"power":        "on",
 		"rssi":         200,
-		"setpoint":     200,
-		"setpoint_max": 300,
-		"setpoint_min": 160,
-		"temperature":  210,
+		"setpoint":     20.0,
+		"setpoint_max": 30.0,
+		"setpoint_min": 16.0,
+		"temperature":  21.0,
 	}
 	_testStateVerifyUnmapped = map[string]interface{}{
 		"alarm_status": 0,
@@ -76,7 +80,7 @@ func TestDevices(t *testing.T) {
 				t.Errorf("mock http server problem: %v", err.Error())
 				return
 			}
-			ih := New("u", "p", WithHostname(s.URL))
+			ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
 			devices, err := ih.Devices()
 			tt.want(t, devices, err)
 		})
@@ -131,7 +135,7 @@ func TestHasDevice(t *testing.T) {
 				t.Errorf("mock http server problem: %v", err.Error())
 				return
 			}
-			ih := New("u", "p", WithHostname(s.URL))
+			ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
 			ok, err := ih.HasDevice(tt.device)
 			tt.want(t, ok, err)
 		})
@@ -145,12 +149,14 @@ func TestStatus(t *testing.T) {
 		name    string
 		code    int
 		payload string
+		device  string
 		want    inlineStatusCheck
 	}{
 		{
 			"valid response",
 			http.StatusOK,
 			testValidControlResponsePayload,
+			testDeviceId,
 			func(t *testing.T, s map[string]interface{}, e error) {
 				assert.NoError(t, e)
 				for k, v := range _testStateVerifyUnmapped {
@@ -168,10 +174,20 @@ func TestStatus(t *testing.T) {
 			"invalid response",
 			http.StatusOK,
 			testErrorControlResponsePayload,
+			testDeviceId,
 			func(t *testing.T, s map[string]interface{}, e error) {
 				assert.Error(t, e)
 			},
 		},
+		{
+			"unknown device",
+			http.StatusOK,
+			testValidControlResponsePayload,
+			"12345",
+			func(t *testing.T, s map[string]interface{}, e error) {
+				assert.ErrorIs(t, e, ErrDeviceNotFound)
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -180,14 +196,33 @@ func TestStatus(t *testing.T) {
 				t.Errorf("mock http server problem: %v", err.Error())
 				return
 			}
-			ih := New("u", "p", WithHostname(s.URL))
-			d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+			ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
+			d, _ := strconv.ParseInt(tt.device, 10, 64)
 			state, err := ih.Status(int64(d))
 			tt.want(t, state, err)
 		})
 	}
 }
 
+func TestContextCancellation(t *testing.T) {
+	s, err := mockHTTPServer(http.StatusOK, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer s.Close()
+	ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ih.DevicesContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = ih.StatusContext(ctx, 127934703953)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = ih.HasDeviceContext(ctx, 127934703953)
+	assert.ErrorIs(t, err, context.Canceled)
+	err = ih.SetContext(ctx, 127934703953, 1, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
 func mockHTTPServer(responseCode int, payloadFile string) (s *httptest.Server, err error) {
 	body, err := os.ReadFile(payloadFile)
 	if err != nil {
@@ -0,0 +1,251 @@
+package intesishome
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	LocalEndpoint string = "/api.cgi"
+	_localInstall string = "local" // the name of the installation a local device is placed within
+)
+
+// the local API commands
+const (
+	localLogin    string = "login"
+	localInfo     string = "getinfo"
+	localGetValue string = "getdatapointvalue"
+	localSetValue string = "setdatapointvalue"
+)
+
+// an error reported by a device's local API
+type LocalError struct {
+	Code    int
+	Message string
+}
+
+func (e *LocalError) Error() string {
+	return fmt.Sprintf("unexpected local api error: %v message: %v", e.Code, e.Message)
+}
+
+// the session id is unknown or has expired, pyIntesisHome logs in again for both
+func (e *LocalError) expired() bool {
+	return e.Code == 1 || e.Code == 5
+}
+
+// requests & responses as spoken by api.cgi
+type localRequest struct {
+	Command string                 `json:"command"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+type localResponse struct {
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// what the device says about itself via getinfo
+type LocalInfo struct {
+	DeviceModel string `json:"deviceModel"`
+	SN          string `json:"sn"`
+	OwnSSID     string `json:"ownSSID"`
+	MAC         string `json:"wlanSTAMAC"`
+	FwVersion   string `json:"fwVersion"`
+	Rssi        int    `json:"rssi"`
+}
+
+// the device id, the serial number where it's a number otherwise the MAC address
+func (i LocalInfo) id() int64 {
+	if id, err := strconv.ParseInt(i.SN, 10, 64); err == nil {
+		return id
+	}
+	mac := strings.NewReplacer(":", "", "-", "").Replace(i.MAC)
+	if id, err := strconv.ParseInt(mac, 16, 64); err == nil {
+		return id
+	}
+	return 1
+}
+
+// a WMP device's own HTTP API on the local network, as used by pyIntesisHome's local mode
+// it keeps control working when the Intesis Cloud is down. the device is presented as the
+// only one within an installation named local & uses the same uids as the cloud
+type local struct {
+	ih        *IntesisHome // for the HTTP client, credentials & verbosity
+	uri       string
+	sessionID string
+	info      *LocalInfo // fetched once per client
+	mu        sync.Mutex // one request at a time, the devices don't cope with more
+}
+
+// talks to the device's local HTTP API at host rather than the Intesis Cloud, the username
+// & password are the device's own. nothing is pushed so Subscribe fails & callers poll instead
+func WithLocal(host string) Option {
+	return func(ih *IntesisHome) {
+		if host == "" {
+			return
+		}
+		if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+			host = "http://" + host
+		}
+		ih.backend = &local{ih: ih, uri: strings.TrimSuffix(host, "/") + LocalEndpoint}
+	}
+}
+
+// the device & every uid it reports, shaped as a control response
+func (l *local) control(ctx context.Context) (r ControlResponse, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.info == nil {
+		var data struct {
+			Info LocalInfo `json:"info"`
+		}
+		if err = l.request(ctx, localInfo, nil, &data); err != nil {
+			return
+		}
+		l.info = &data.Info
+	}
+	var data struct {
+		Values []struct {
+			Uid   int `json:"uid"`
+			Value int `json:"value"`
+		} `json:"dpval"`
+	}
+	if err = l.request(ctx, localGetValue, map[string]interface{}{"uid": "all"}, &data); err != nil {
+		return
+	}
+	id := l.info.id()
+	name := l.info.OwnSSID
+	if name == "" {
+		name = l.info.DeviceModel
+	}
+	r.Config.Inst = []Installation{{
+		Name:    _localInstall,
+		Devices: []Device{{ID: fmt.Sprint(id), Name: name}},
+	}}
+	for _, v := range data.Values {
+		r.Status.Status = append(r.Status.Status, StatusValue{DeviceID: id, UID: v.Uid, Value: v.Value})
+	}
+	r.ConfigChanged, r.StatusChanged = true, true
+	return
+}
+
+// sets the uid on the device, the ack only carries the device
+func (l *local) set(ctx context.Context, device int64, uid, value int) (ack Ack, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.info != nil && l.info.id() != device {
+		err = newError(ErrDeviceNotFound, "device not found: %v", device)
+		return
+	}
+	if err = l.request(ctx, localSetValue, map[string]interface{}{"uid": uid, "value": value}, nil); err != nil {
+		return
+	}
+	ack.DeviceID = device
+	return
+}
+
+// forgets the session, the device expires it on its own
+func (l *local) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessionID = ""
+}
+
+// sends the command along with the session id, logging in first if need be or
+// again if the device has forgotten the session. callers must hold l.mu
+func (l *local) request(ctx context.Context, command string, data map[string]interface{}, out interface{}) error {
+	if l.sessionID == "" {
+		if err := l.login(ctx); err != nil {
+			return err
+		}
+	}
+	err := l.post(ctx, command, l.withSession(data), out)
+	if lerr, ok := err.(*LocalError); ok && lerr.expired() {
+		if l.ih.verbose {
+			fmt.Printf("DEBUG|local| session expired, logging in again: %v\n", err)
+		}
+		if err = l.login(ctx); err != nil {
+			return err
+		}
+		err = l.post(ctx, command, l.withSession(data), out)
+	}
+	return err
+}
+
+// obtains a fresh session id
+func (l *local) login(ctx context.Context) error {
+	var data struct {
+		ID struct {
+			SessionID string `json:"sessionID"`
+		} `json:"id"`
+	}
+	l.sessionID = ""
+	creds := map[string]interface{}{"username": l.ih.username, "password": l.ih.password}
+	if err := l.post(ctx, localLogin, creds, &data); err != nil {
+		return err
+	}
+	if data.ID.SessionID == "" {
+		return newError(ErrProtocol, "local api login returned no session id")
+	}
+	l.sessionID = data.ID.SessionID
+	return nil
+}
+
+// a copy of data with the session id added
+func (l *local) withSession(data map[string]interface{}) map[string]interface{} {
+	d := map[string]interface{}{"sessionID": l.sessionID}
+	for k, v := range data {
+		d[k] = v
+	}
+	return d
+}
+
+// posts a single command to api.cgi & decodes the data within the response into out
+func (l *local) post(ctx context.Context, command string, data map[string]interface{}, out interface{}) error {
+	body, err := json.Marshal(localRequest{Command: command, Data: data})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.ih.client.Do(req)
+	if err != nil {
+		return networkError(err)
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return networkError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	var r localResponse
+	if err = json.Unmarshal(body, &r); err != nil {
+		return newError(ErrProtocol, "malformed local api payload: %v", err.Error())
+	}
+	if !r.Success {
+		return &LocalError{Code: r.Error.Code, Message: r.Error.Message}
+	}
+	if out == nil {
+		return nil
+	}
+	if err = json.Unmarshal(r.Data, out); err != nil {
+		return newError(ErrProtocol, "malformed local api %s data: %v", command, err.Error())
+	}
+	return nil
+}
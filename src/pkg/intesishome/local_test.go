@@ -0,0 +1,90 @@
+package intesishome_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/nullify005/service-intesis/pkg/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+const _testLocalDevice int64 = 127934703953
+
+func TestLocal(t *testing.T) {
+	newLocal := func(t *testing.T, user, pass string) (*intesishome.IntesisHome, *mock.LocalServer) {
+		m := mock.NewLocalServer()
+		s := httptest.NewServer(m.Handler())
+		t.Cleanup(s.Close)
+		ih := intesishome.New(user, pass, intesishome.WithLocal(s.URL), intesishome.WithSetDelay(0))
+		t.Cleanup(ih.Close)
+		return ih, m
+	}
+
+	t.Run("devices", func(t *testing.T) {
+		ih, _ := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		devices, err := ih.Devices()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(devices))
+		assert.Equal(t, "127934703953", devices[0].ID)
+		assert.Equal(t, "DEVICE_AABBCC", devices[0].Name)
+	})
+	t.Run("status", func(t *testing.T) {
+		ih, _ := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		status, err := ih.Status(_testLocalDevice)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, status["power"])
+		state, err := ih.State(_testLocalDevice)
+		assert.NoError(t, err)
+		assert.Equal(t, intesishome.PowerOn, *state.Power)
+	})
+	t.Run("set", func(t *testing.T) {
+		ih, m := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		uid, value, err := ih.MapCommand(_testLocalDevice, "setpoint", 22.5)
+		assert.NoError(t, err)
+		assert.NoError(t, ih.Set(_testLocalDevice, uid, value))
+		v, _ := m.Value(9)
+		assert.Equal(t, 225, v)
+		state, err := ih.State(_testLocalDevice)
+		assert.NoError(t, err)
+		assert.Equal(t, 22.5, *state.Setpoint)
+	})
+	t.Run("set many & confirm", func(t *testing.T) {
+		ih, m := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		results, err := ih.SetMany(_testLocalDevice, []intesishome.Command{{Uid: 1, Value: 0}, {Uid: 2, Value: 4}})
+		assert.NoError(t, err)
+		assert.Equal(t, _testLocalDevice, results[1].Ack.DeviceID)
+		v, _ := m.Value(2)
+		assert.Equal(t, 4, v)
+		confirmed, err := ih.SetAndConfirm(_testLocalDevice, 2, 1, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, intesishome.ConfirmApplied, confirmed)
+	})
+	t.Run("logs in again once the session expires", func(t *testing.T) {
+		ih, m := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		_, err := ih.Devices()
+		assert.NoError(t, err)
+		m.Expire()
+		assert.NoError(t, ih.Set(_testLocalDevice, 1, 0))
+	})
+	t.Run("bad credentials", func(t *testing.T) {
+		ih, _ := newLocal(t, "admin", "wrong")
+		_, err := ih.Devices()
+		var lerr *intesishome.LocalError
+		assert.ErrorAs(t, err, &lerr)
+		assert.False(t, intesishome.Retryable(err))
+	})
+	t.Run("unknown device", func(t *testing.T) {
+		ih, _ := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		_, err := ih.Devices()
+		assert.NoError(t, err)
+		assert.ErrorIs(t, ih.Set(1, 1, 0), intesishome.ErrDeviceNotFound)
+	})
+	t.Run("nothing is pushed", func(t *testing.T) {
+		ih, _ := newLocal(t, mock.DefaultLocalUsername, mock.DefaultLocalPassword)
+		_, err := ih.Subscribe(context.Background())
+		assert.Error(t, err)
+	})
+}
@@ -31,6 +31,18 @@ func TestDecodeState(t *testing.T) {
 			-1,
 			nil,
 		},
+		{
+			"scaled value",
+			"outdoor_temp",
+			65526,
+			-1.0,
+		},
+		{
+			"unavailable value",
+			"setpoint_min",
+			32768,
+			nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -65,3 +77,40 @@ func TestDecodeUid(t *testing.T) {
 	}
 
 }
+
+func TestMapCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		uid   int
+		want  int
+		err   string
+	}{
+		{"enum", "power", "on", 1, 1, ""},
+		{"raw enum", "mode", 4, 2, 4, ""},
+		{"scaled float", "setpoint", 21.5, 9, 215, ""},
+		{"scaled string", "setpoint", "21.5", 9, 215, ""},
+		{"scaled int", "setpoint", 21, 9, 210, ""},
+		{"signed", "heat_thermo_shift", -2, 55, 65534, ""},
+		{"unknown key", "bogus", 1, 0, 0, "key not present in command map"},
+		{"unknown value", "power", "sideways", 1, 0, "no such value"},
+		{"name for a number", "setpoint", "warm", 9, 0, "expected a number"},
+		{"below the minimum", "heat_high_water_set_temperature", 20, 0, 0, "below the minimum: 25"},
+		{"above the maximum", "thermoshift_heat_eco", "6", 0, 0, "above the maximum: 5"},
+		{"within range", "heat_low_outdoor_set_temperature", -15, 134, 65521, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, v, err := MapCommand(tt.key, tt.value)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				assert.ErrorIs(t, err, ErrInvalidCommand)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.uid, uid)
+			assert.Equal(t, tt.want, v)
+		})
+	}
+}
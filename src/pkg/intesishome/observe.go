@@ -0,0 +1,35 @@
+package intesishome
+
+import "time"
+
+// told how the client's dealings with the Intesis Cloud & the TCP gateway went, such as
+// for metrics. it's called inline so it must be quick & safe for concurrent use
+type Observer interface {
+	// each request to the control endpoint, err is nil when it succeeded
+	ObserveRequest(d time.Duration, err error)
+	// each set sent over the TCP gateway, from writing it to its ack
+	ObserveSet(d time.Duration, err error)
+	// each attempt to obtain a fresh token for the TCP gateway
+	ObserveTokenRefresh(err error)
+}
+
+// the observer of the client's requests, sets & token refreshes
+func WithObserver(o Observer) Option {
+	return func(ih *IntesisHome) {
+		ih.observer = o
+	}
+}
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveRequest(time.Duration, error) {}
+func (nopObserver) ObserveSet(time.Duration, error)     {}
+func (nopObserver) ObserveTokenRefresh(error)           {}
+
+// the observer, one which ignores everything unless given
+func (ih *IntesisHome) observe() Observer {
+	if ih.observer == nil {
+		return nopObserver{}
+	}
+	return ih.observer
+}
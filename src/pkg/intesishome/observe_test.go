@@ -0,0 +1,90 @@
+package intesishome
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// remembers the outcome of everything it's told about
+type recordingObserver struct {
+	requests []error
+	sets     []error
+	tokens   []error
+	mu       sync.Mutex
+}
+
+func (o *recordingObserver) ObserveRequest(d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, err)
+}
+
+func (o *recordingObserver) ObserveSet(d time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sets = append(o.sets, err)
+}
+
+func (o *recordingObserver) ObserveTokenRefresh(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.tokens = append(o.tokens, err)
+}
+
+func TestObserver(t *testing.T) {
+	t.Run("requests, token refreshes & sets", func(t *testing.T) {
+		h, err := mockHTTPServer(http.StatusOK, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		addr, _ := testGatewayListener(t, okGateway)
+		o := &recordingObserver{}
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithObserver(o))
+		defer ih.Close()
+		d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+		_, err = ih.StatusAll()
+		assert.NoError(t, err)
+		assert.NoError(t, ih.Set(d, 1, 1))
+		assert.NoError(t, ih.Set(d, 1, 0))
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		assert.Equal(t, []error{nil, nil}, o.requests, "the poll & the token refresh")
+		assert.Equal(t, []error{nil}, o.tokens, "the session is shared")
+		assert.Equal(t, []error{nil, nil}, o.sets)
+	})
+	t.Run("failures", func(t *testing.T) {
+		h, err := mockHTTPServer(http.StatusInternalServerError, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		o := &recordingObserver{}
+		ih := New("u", "p", WithHostname(h.URL), WithObserver(o), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		defer ih.Close()
+		_, err = ih.StatusAll()
+		assert.Error(t, err)
+		assert.Error(t, ih.Set(1, 1, 1))
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		assert.Equal(t, 2, len(o.requests))
+		for _, err := range o.requests {
+			var httpErr *HTTPError
+			assert.True(t, errors.As(err, &httpErr))
+		}
+		assert.Equal(t, 1, len(o.tokens))
+		assert.Error(t, o.tokens[0])
+		assert.Empty(t, o.sets, "nothing was sent")
+	})
+	t.Run("nothing is needed", func(t *testing.T) {
+		assert.Equal(t, nopObserver{}, (&IntesisHome{}).observe())
+	})
+}
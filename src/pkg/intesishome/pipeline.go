@@ -0,0 +1,135 @@
+package intesishome
+
+import (
+	"context"
+	"fmt"
+)
+
+// a uid & raw value to set on a device
+type Command struct {
+	Uid   int `json:"uid"`
+	Value int `json:"value"`
+}
+
+// the outcome of a single command
+type SetResult struct {
+	Command Command `json:"command"`
+	Ack     Ack     `json:"ack"`
+	Err     error   `json:"-"`
+}
+
+// sends every command over the one connection without waiting on each ack in between
+// acks are matched to their command by sequence number. establishing the session is
+// retried according to the RetryPolicy but the commands aren't, since some may have applied
+func (ih *IntesisHome) Pipeline(device int64, cmds []Command) ([]SetResult, error) {
+	return ih.PipelineContext(context.Background(), device, cmds)
+}
+
+// sends every command over the one connection without waiting on each ack in between,
+// giving up once ctx is done. err is the first failure, each result carries its own
+func (ih *IntesisHome) PipelineContext(ctx context.Context, device int64, cmds []Command) (results []SetResult, err error) {
+	done := make(chan []SetResult, 1)
+	err = ih.commands().submit(ctx, func(ctx context.Context) error {
+		g, err := ih.retriedGateway(ctx)
+		if err != nil {
+			return err
+		}
+		results := make([]SetResult, len(cmds))
+		s, ok := g.(*session)
+		if !ok {
+			// only the TCP gateway can have several sets in flight, so one at a time
+			for i, c := range cmds {
+				results[i].Command = c
+				results[i].Ack, results[i].Err = g.set(ctx, device, c.Uid, c.Value)
+			}
+			done <- results
+			return firstErr(results)
+		}
+		pending := make([]pendingSet, len(cmds))
+		for i, c := range cmds {
+			results[i].Command = c
+			pending[i], results[i].Err = s.send(ctx, device, c.Uid, c.Value)
+		}
+		for i := range cmds {
+			if results[i].Err == nil {
+				results[i].Ack, results[i].Err = s.await(ctx, pending[i])
+			}
+		}
+		done <- results
+		return firstErr(results)
+	})
+	// the results are only there if the commands were sent
+	select {
+	case results = <-done:
+	default:
+	}
+	return
+}
+
+// sends each command in order over the one connection, waiting on each ack & the set delay
+// before the next. the session is established once, retried according to the RetryPolicy.
+// sending stops at the first failure, the commands after it are left with ErrNotSent
+func (ih *IntesisHome) SetMany(device int64, cmds []Command) ([]SetResult, error) {
+	return ih.SetManyContext(context.Background(), device, cmds)
+}
+
+// sends each command in order over the one connection, giving up once ctx is done.
+// err is the failure which stopped the batch, each result carries its own
+func (ih *IntesisHome) SetManyContext(ctx context.Context, device int64, cmds []Command) (results []SetResult, err error) {
+	if ih.err != nil {
+		return nil, ih.err
+	}
+	done := make(chan []SetResult, 1)
+	err = ih.commands().submit(ctx, func(ctx context.Context) error {
+		g, err := ih.retriedGateway(ctx)
+		if err != nil {
+			return err
+		}
+		results := make([]SetResult, len(cmds))
+		for i, c := range cmds {
+			results[i] = SetResult{Command: c, Err: ErrNotSent}
+		}
+		defer func() { done <- results }()
+		for i, c := range cmds {
+			if i > 0 {
+				ih.mu.Lock()
+				delay := ih.setDelay
+				ih.mu.Unlock()
+				if !sleepCtx(ctx, delay) {
+					err = fmt.Errorf("abandoned waiting on the set delay: %w", networkError(ctx.Err()))
+					results[i].Err = err
+					return err
+				}
+			}
+			if results[i].Ack, results[i].Err = g.set(ctx, device, c.Uid, c.Value); results[i].Err != nil {
+				return results[i].Err
+			}
+		}
+		return nil
+	})
+	// the results are only there if the batch was started
+	select {
+	case results = <-done:
+	default:
+	}
+	return
+}
+
+// the gateway, establishing the session according to the RetryPolicy
+func (ih *IntesisHome) retriedGateway(ctx context.Context) (g gateway, err error) {
+	err = ih.retry.do(ctx, ih.verbose, func() (err error) {
+		g, err = ih.gateway(ctx)
+		return
+	})
+	return
+}
+
+// the first failure amongst the results
+func firstErr(results []SetResult) error {
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package intesishome
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	h, err := mockHTTPServer(200, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer h.Close()
+	cmds := []Command{{Uid: 1, Value: 1}, {Uid: 2, Value: 4}, {Uid: 9, Value: 215}}
+
+	// only answers once every set has arrived, in reverse order, so the client must
+	// have sent them all without waiting & matched the acks up
+	reversingGateway := func(invalid int) gatewayReplyFunc {
+		var sets []string
+		return func(t *testing.T, req string) []string {
+			if !isSet(t, req) {
+				return okGateway(t, req)
+			}
+			sets = append(sets, req)
+			if len(sets) < len(cmds) {
+				return []string{}
+			}
+			acks := []string{}
+			for i := len(sets) - 1; i >= 0; i-- {
+				tmpl := _testSetAckTmpl
+				if i == invalid {
+					tmpl = _testSetInvalid
+				}
+				acks = append(acks, ackFor(t, sets[i], tmpl))
+			}
+			return acks
+		}
+	}
+
+	t.Run("acks out of order", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, reversingGateway(-1))
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0))
+		defer ih.Close()
+		results, err := ih.Pipeline(d, cmds)
+		assert.NoError(t, err)
+		assert.Equal(t, len(cmds), len(results))
+		for i, r := range results {
+			assert.NoError(t, r.Err)
+			assert.Equal(t, cmds[i], r.Command)
+			assert.Equal(t, d, r.Ack.DeviceID)
+			assert.Equal(t, 198, r.Ack.Rssi)
+			if i > 0 {
+				assert.Greater(t, r.Ack.SeqNo, results[i-1].Ack.SeqNo)
+			}
+		}
+	})
+	t.Run("results are per command", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, reversingGateway(1))
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0))
+		defer ih.Close()
+		results, err := ih.Pipeline(d, cmds)
+		assert.ErrorIs(t, err, ErrProtocol)
+		assert.NoError(t, results[0].Err)
+		assert.ErrorContains(t, results[1].Err, "expected: set_ack got: garbage")
+		assert.NoError(t, results[2].Err)
+	})
+}
+
+func TestSetMany(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	h, err := mockHTTPServer(200, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer h.Close()
+	cmds := []Command{{Uid: 1, Value: 1}, {Uid: 2, Value: 4}, {Uid: 9, Value: 215}}
+
+	// acks each set as it arrives, refusing the one with the invalid value
+	orderedGateway := func(invalid int) (gatewayReplyFunc, *[]int) {
+		var sent []int
+		return func(t *testing.T, req string) []string {
+			if !isSet(t, req) {
+				return okGateway(t, req)
+			}
+			cmd := decodeRequest(t, req)
+			sent = append(sent, cmd.Data.Uid)
+			if cmd.Data.Value == invalid {
+				return []string{ackFor(t, req, _testSetInvalid)}
+			}
+			return []string{ackFor(t, req, _testSetAckTmpl)}
+		}, &sent
+	}
+
+	t.Run("in order", func(t *testing.T) {
+		gw, sent := orderedGateway(-1)
+		addr, _ := testGatewayListener(t, gw)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0))
+		defer ih.Close()
+		results, err := ih.SetMany(d, cmds)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 9}, *sent)
+		for i, r := range results {
+			assert.NoError(t, r.Err)
+			assert.Equal(t, cmds[i], r.Command)
+			assert.Equal(t, d, r.Ack.DeviceID)
+		}
+	})
+	t.Run("stops at the first failure", func(t *testing.T) {
+		gw, sent := orderedGateway(4)
+		addr, _ := testGatewayListener(t, gw)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0))
+		defer ih.Close()
+		results, err := ih.SetMany(d, cmds)
+		assert.ErrorIs(t, err, ErrProtocol)
+		assert.Equal(t, []int{1, 2}, *sent, "nothing is sent after the failure")
+		assert.NoError(t, results[0].Err)
+		assert.ErrorIs(t, results[1].Err, ErrProtocol)
+		assert.ErrorIs(t, results[2].Err, ErrNotSent)
+	})
+}
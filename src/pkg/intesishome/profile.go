@@ -0,0 +1,145 @@
+package intesishome
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	_vaneSwingBit int = 10 // config_*_vanes bit for swing, bits 1 to 9 are the manual positions
+)
+
+// which modes, fan speeds & vane positions a device has & what each is called, built
+// from the config uids the device reports or failing that the model table
+// a nil map means the device didn't say, so anything goes
+type Profile struct {
+	Modes     map[int]string `json:"modes,omitempty"`
+	FanSpeeds map[int]string `json:"fan_speeds,omitempty"`
+	VVanes    map[int]string `json:"vvanes,omitempty"`
+	HVanes    map[int]string `json:"hvanes,omitempty"`
+}
+
+// builds the profile for the device from the raw values it reports, keyed by their decoded uid
+func newProfile(reg *Registry, d Device, status map[string]interface{}) (p Profile) {
+	config := make(map[string]int)
+	if m, ok := reg.Model(d.FamilyID, d.ModelID); ok {
+		for name, v := range m.Config {
+			config[name] = v
+		}
+	}
+	for _, name := range []string{"config_mode_map", "config_fan_map", "config_horizontal_vanes", "config_vertical_vanes"} {
+		if v, ok := status[name].(int); ok {
+			config[name] = v
+		}
+	}
+	if v, ok := config["config_mode_map"]; ok {
+		// bit n is the mode with raw value n
+		p.Modes = bitNames(reg, v, "mode", 0, 4)
+	}
+	if v, ok := config["config_fan_map"]; ok {
+		p.FanSpeeds = fanSpeeds(reg, v)
+	}
+	if v, ok := config["config_vertical_vanes"]; ok {
+		p.VVanes = vanes(reg, v, "vvane")
+	}
+	if v, ok := config["config_horizontal_vanes"]; ok {
+		p.HVanes = vanes(reg, v, "hvane")
+	}
+	return
+}
+
+// the names of the values whose bit is set within mask, from lo to hi inclusive
+func bitNames(reg *Registry, mask int, name string, lo, hi int) map[int]string {
+	names := make(map[int]string)
+	e, _ := reg.Name(name)
+	for n := lo; n <= hi; n++ {
+		if mask&(1<<n) != 0 {
+			names[n] = fmt.Sprint(e.Decode(n))
+		}
+	}
+	return names
+}
+
+// the speeds for the config_fan_map value via the state map
+func fanSpeeds(reg *Registry, fanMap int) map[int]string {
+	speeds := make(map[int]string)
+	e, _ := reg.Name("config_fan_map")
+	m, ok := e.Values[fanMap].(map[string]interface{})
+	if !ok {
+		return speeds
+	}
+	for raw, name := range m {
+		if n, err := strconv.Atoi(raw); err == nil {
+			speeds[n] = fmt.Sprint(name)
+		}
+	}
+	return speeds
+}
+
+// the positions for a config_*_vanes value, auto/stop is there whenever the vane is
+func vanes(reg *Registry, config int, name string) map[int]string {
+	if config == 0 {
+		return make(map[int]string)
+	}
+	positions := bitNames(reg, config, name, 1, _vaneSwingBit)
+	positions[int(VaneAutoStop)] = fmt.Sprint(reg.DecodeState(name, int(VaneAutoStop)))
+	return positions
+}
+
+// what the device calls each value of the named uid or command, nil when the profile doesn't govern it
+func (p Profile) Names(name string) map[int]string {
+	switch name {
+	case "mode":
+		return p.Modes
+	case "fan_speed":
+		return p.FanSpeeds
+	case "vvane":
+		return p.VVanes
+	case "hvane":
+		return p.HVanes
+	}
+	return nil
+}
+
+// whether profiles govern the named uid or command, even where a device's doesn't say
+func profiled(name string) bool {
+	switch name {
+	case "mode", "fan_speed", "vvane", "hvane":
+		return true
+	}
+	return false
+}
+
+// what the device calls the raw value
+func (p Profile) Decode(name string, value int) (v string, ok bool) {
+	v, ok = p.Names(name)[value]
+	return
+}
+
+// the raw value for what the device calls it
+func (p Profile) Encode(name, value string) (raw int, ok bool) {
+	for raw, v := range p.Names(name) {
+		if v == value {
+			return raw, true
+		}
+	}
+	return
+}
+
+// whether the device has the raw value, anything goes where the profile doesn't say
+func (p Profile) Supports(name string, value int) bool {
+	values := p.Names(name)
+	if values == nil {
+		return true
+	}
+	_, ok := values[value]
+	return ok
+}
+
+// decodes the value via the device's profile, falling back to the state map
+func (d DeviceStatus) DecodeState(name string, value int) interface{} {
+	if v, ok := d.Profile.Decode(name, value); ok {
+		return v
+	}
+	return d.State.mappings().DecodeState(name, value)
+}
@@ -0,0 +1,101 @@
+package intesishome
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfile(t *testing.T) {
+	t.Run("from the config uids", func(t *testing.T) {
+		p := newProfile(DefaultRegistry, Device{}, map[string]interface{}{
+			"config_mode_map":         17,   // auto & cool
+			"config_fan_map":          31,   // auto, quiet, low, medium, high
+			"config_horizontal_vanes": 1030, // manual1, manual2 & swing
+			"config_vertical_vanes":   0,    // no vane
+		})
+		assert.Equal(t, map[int]string{0: "auto", 4: "cool"}, p.Modes)
+		assert.Equal(t, map[int]string{0: "auto", 1: "quiet", 2: "low", 3: "medium", 4: "high"}, p.FanSpeeds)
+		assert.Equal(t, map[int]string{0: "auto/stop", 1: "manual1", 2: "manual2", 10: "swing"}, p.HVanes)
+		assert.Equal(t, map[int]string{}, p.VVanes)
+	})
+	t.Run("from the model table", func(t *testing.T) {
+		p := newProfile(DefaultRegistry, Device{FamilyID: 3840, ModelID: 79}, map[string]interface{}{"config_mode_map": 2})
+		assert.Equal(t, map[int]string{1: "heat"}, p.Modes, "what the device reports wins")
+		assert.Equal(t, "quiet", p.FanSpeeds[1])
+		assert.Nil(t, p.HVanes)
+	})
+	t.Run("from the registry's model table", func(t *testing.T) {
+		r, err := LoadMappings(testMappingOverlay)
+		assert.NoError(t, err)
+		d := Device{FamilyID: 3840, ModelID: 81}
+		assert.Equal(t, "low", newProfile(r, d, map[string]interface{}{}).FanSpeeds[1])
+		assert.Nil(t, newProfile(DefaultRegistry, d, map[string]interface{}{}).FanSpeeds)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		p := newProfile(DefaultRegistry, Device{}, map[string]interface{}{})
+		assert.Equal(t, Profile{}, p)
+		assert.True(t, p.Supports("mode", 3))
+	})
+	t.Run("names both ways", func(t *testing.T) {
+		p := Profile{FanSpeeds: map[int]string{1: "quiet", 2: "low"}}
+		raw, ok := p.Encode("fan_speed", "quiet")
+		assert.True(t, ok)
+		assert.Equal(t, 1, raw)
+		name, ok := p.Decode("fan_speed", 2)
+		assert.True(t, ok)
+		assert.Equal(t, "low", name)
+		_, ok = p.Encode("power", "on")
+		assert.False(t, ok, "only modes, fan speeds & vanes are governed")
+		assert.False(t, p.Supports("fan_speed", 5))
+	})
+}
+
+func TestDeviceProfile(t *testing.T) {
+	s, err := mockHTTPServer(http.StatusOK, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer s.Close()
+	ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	snapshot, err := ih.StatusAll()
+	assert.NoError(t, err)
+	dev, _ := snapshot.Device(d)
+	assert.Equal(t, 5, len(dev.Profile.Modes))
+	assert.Equal(t, map[int]string{0: "auto/stop", 1: "manual1", 2: "manual2", 3: "manual3", 4: "manual4", 10: "swing"}, dev.Profile.VVanes)
+	assert.Equal(t, "auto", dev.DecodeState("fan_speed", 0))
+	assert.Equal(t, "auto", dev.State.Values()["fan_speed"])
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		uid   int
+		want  int
+		err   string
+	}{
+		{"device fan speed name", "fan_speed", "quiet", 4, 1, ""},
+		{"raw fan speed", "fan_speed", 4, 4, 4, ""},
+		{"unsupported fan speed", "fan_speed", 5, 0, 0, "is not supported by device"},
+		{"unsupported vane position", "vvane", "manual5", 0, 0, "is not supported by device"},
+		{"unknown name", "fan_speed", "turbo", 0, 0, "expected a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, v, err := ih.MapCommand(d, tt.key, tt.value)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				assert.ErrorIs(t, err, ErrInvalidCommand)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.uid, uid)
+			assert.Equal(t, tt.want, v)
+		})
+	}
+	_, _, err = ih.MapCommand(1, "power", "on")
+	assert.ErrorIs(t, err, ErrDeviceNotFound)
+}
@@ -0,0 +1,104 @@
+package intesishome
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var errClientClosed = newError(ErrUnreachable, "client closed")
+
+// a command waiting its turn with the gateway
+type queuedCommand struct {
+	ctx    context.Context
+	run    func(ctx context.Context) error
+	result chan error // receives the outcome, buffered so the worker never blocks on it
+}
+
+// hands commands to a single worker one at a time, so concurrent callers never
+// interleave their exchanges with the gateway & are spaced out by the set delay
+type commandQueue struct {
+	requests chan *queuedCommand
+	quit     chan struct{}
+}
+
+// overrides the pause between commands which the Intesis Cloud asks for
+func WithSetDelay(d time.Duration) Option {
+	return func(ih *IntesisHome) {
+		ih.setDelay = d
+		ih.fixedDelay = true
+	}
+}
+
+// the command queue, starting its worker if need be
+func (ih *IntesisHome) commands() *commandQueue {
+	ih.queueMu.Lock()
+	defer ih.queueMu.Unlock()
+	if ih.queue == nil {
+		ih.queue = &commandQueue{
+			requests: make(chan *queuedCommand),
+			quit:     make(chan struct{}),
+		}
+		go ih.work(ih.queue)
+	}
+	return ih.queue
+}
+
+// stops the worker, commands still queued fail with errClientClosed
+func (ih *IntesisHome) stopCommands() {
+	ih.queueMu.Lock()
+	defer ih.queueMu.Unlock()
+	if ih.queue != nil {
+		close(ih.queue.quit)
+		ih.queue = nil
+	}
+}
+
+// queues fn & waits for its outcome, giving up once ctx is done
+func (q *commandQueue) submit(ctx context.Context, fn func(ctx context.Context) error) error {
+	cmd := &queuedCommand{ctx: ctx, run: fn, result: make(chan error, 1)}
+	select {
+	case q.requests <- cmd:
+	case <-q.quit:
+		return errClientClosed
+	case <-ctx.Done():
+		return fmt.Errorf("abandoned waiting for the command queue: %w", networkError(ctx.Err()))
+	}
+	select {
+	case err := <-cmd.result:
+		return err
+	case <-ctx.Done():
+		// the worker notices ctx too, so the command is abandoned as well
+		return fmt.Errorf("abandoned waiting on the command: %w", networkError(ctx.Err()))
+	}
+}
+
+// runs queued commands one at a time until the queue is stopped
+func (ih *IntesisHome) work(q *commandQueue) {
+	var last time.Time // when the previous command finished
+	for {
+		select {
+		case <-q.quit:
+			return
+		case cmd := <-q.requests:
+			ih.mu.Lock()
+			delay := ih.setDelay
+			ih.mu.Unlock()
+			if wait := time.Until(last.Add(delay)); wait > 0 {
+				if ih.verbose {
+					fmt.Printf("DEBUG|queue| waiting %v before the next command\n", wait)
+				}
+				if !sleepCtx(cmd.ctx, wait) {
+					cmd.result <- networkError(cmd.ctx.Err())
+					continue
+				}
+			}
+			if err := cmd.ctx.Err(); err != nil {
+				cmd.result <- networkError(err)
+				continue
+			}
+			cmd.result <- cmd.run(cmd.ctx)
+			last = time.Now()
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package intesishome
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandQueue(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	h, err := mockHTTPServer(200, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer h.Close()
+
+	t.Run("each caller gets its own result", func(t *testing.T) {
+		addr, accepts := testGatewayListener(t, func(t *testing.T, req string) []string {
+			cmd := decodeRequest(t, req)
+			if cmd.Command == commandReqSet && cmd.Data.Value == 3 {
+				return []string{ackFor(t, req, _testSetInvalid)}
+			}
+			return okGateway(t, req)
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0))
+		defer ih.Close()
+		errs := make([]error, 6)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = ih.Set(d, 1, i)
+			}(i)
+		}
+		wg.Wait()
+		for i, err := range errs {
+			if i == 3 {
+				assert.ErrorIs(t, err, ErrProtocol)
+				continue
+			}
+			assert.NoError(t, err, "set %v", i)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(accepts))
+	})
+	t.Run("honours the set delay", func(t *testing.T) {
+		var (
+			mu       sync.Mutex
+			arrivals []time.Time
+		)
+		addr, _ := testGatewayListener(t, func(t *testing.T, req string) []string {
+			mu.Lock()
+			arrivals = append(arrivals, time.Now())
+			mu.Unlock()
+			return okGateway(t, req)
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(50*time.Millisecond))
+		defer ih.Close()
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, ih.Set(d, 1, 1))
+			}()
+		}
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 4, len(arrivals), "connect_req & 3 sets")
+		for i := 2; i < len(arrivals); i++ {
+			assert.GreaterOrEqual(t, arrivals[i].Sub(arrivals[i-1]), 50*time.Millisecond)
+		}
+	})
+	t.Run("the cloud sets the delay", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, okGateway)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr))
+		defer ih.Close()
+		assert.NoError(t, ih.Set(d, 1, 1))
+		assert.Equal(t, 700*time.Millisecond, ih.setDelay)
+	})
+	t.Run("gives up whilst queued", func(t *testing.T) {
+		ih := New("u", "p", WithHostname(h.URL))
+		defer ih.Close()
+		release := make(chan struct{})
+		busy := make(chan struct{})
+		go ih.commands().submit(context.Background(), func(ctx context.Context) error {
+			close(busy)
+			<-release
+			return nil
+		})
+		<-busy
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		ran := false
+		err := ih.commands().submit(ctx, func(ctx context.Context) error {
+			ran = true
+			return nil
+		})
+		close(release)
+		assert.ErrorIs(t, err, ErrTimeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.False(t, ran)
+	})
+	t.Run("closed", func(t *testing.T) {
+		ih := New("u", "p", WithHostname(h.URL))
+		q := ih.commands()
+		ih.Close()
+		assert.ErrorIs(t, q.submit(context.Background(), func(ctx context.Context) error { return nil }), ErrUnreachable)
+	})
+}
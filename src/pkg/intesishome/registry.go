@@ -0,0 +1,470 @@
+package intesishome
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	//go:embed "assets/mappingCommand.json"
+	_commandMapJSON []byte
+	//go:embed "assets/mappingState.json"
+	_stateMapJSON []byte
+	//go:embed "assets/mappingModel.json"
+	_modelMapJSON []byte
+
+	// the embedded mappings, used by the package level functions & by clients without
+	// WithRegistry or WithMappings
+	DefaultRegistry = embeddedRegistry()
+)
+
+// a uid reported by a device
+type StateEntry struct {
+	Uid    int
+	Name   string
+	Values map[int]interface{} // raw values to what they mean, usually a name
+	Codec                      // how numeric values convert to engineering units
+	raw    map[string]int      // names to raw values
+}
+
+// what the raw value means, the value in engineering units if the uid has a codec,
+// the raw value if the uid has no names or nil if it has names but not for this value
+func (e StateEntry) Decode(value int) interface{} {
+	if e.Codec.numeric() {
+		if v, ok := e.Codec.Decode(value); ok {
+			return v
+		}
+		// the device can't report it
+		return nil
+	}
+	if e.Values == nil {
+		// there's no human mapping for the value
+		return value
+	}
+	if v, ok := e.Values[value]; ok {
+		return v
+	}
+	return nil
+}
+
+// whether the values are names rather than numbers, either within the state map or
+// via each device's profile as for fan speeds
+func (e StateEntry) Named() bool {
+	if profiled(e.Name) {
+		return true
+	}
+	for _, v := range e.Values {
+		if _, ok := v.(string); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// the raw value for a name
+func (e StateEntry) Raw(name string) (value int, ok bool) {
+	value, ok = e.raw[name]
+	return
+}
+
+// a parameter which can be set on a device
+type CommandEntry struct {
+	Name      string
+	Uid       int
+	Values    map[string]int // names to raw values
+	Min       *float64       // in engineering units
+	Max       *float64       // in engineering units
+	WriteOnly bool           // an action such as resync, which no device reports back
+	names     map[int]string // raw values to names
+}
+
+// the name for a raw value
+func (e CommandEntry) ValueName(value int) (name string, ok bool) {
+	name, ok = e.names[value]
+	return
+}
+
+// checks a value in engineering units against the min / max & encodes it
+func (e CommandEntry) encode(codec Codec, v float64) (raw int, err error) {
+	if e.Min != nil && v < *e.Min {
+		err = newError(ErrInvalidCommand, "value: %v for command: %v is below the minimum: %v", v, e.Name, *e.Min)
+		return
+	}
+	if e.Max != nil && v > *e.Max {
+		err = newError(ErrInvalidCommand, "value: %v for command: %v is above the maximum: %v", v, e.Name, *e.Max)
+		return
+	}
+	if raw, err = codec.Encode(v); err != nil {
+		err = &Error{Kind: ErrInvalidCommand, Err: err}
+	}
+	return
+}
+
+// the config uids a model has when the device doesn't report them itself
+type ModelEntry struct {
+	FamilyID int            `json:"familyId" yaml:"familyId"`
+	ModelID  int            `json:"modelId" yaml:"modelId"`
+	Config   map[string]int `json:"config" yaml:"config"` // config uid names to their raw value
+}
+
+// the uids & commands known to the client, indexed by uid & by name, along with the model table
+// further mappings may be loaded whilst the registry is in use
+type Registry struct {
+	mu       sync.RWMutex
+	states   map[int]StateEntry
+	names    map[string]int // names to the lowest uid carrying it
+	commands map[string]CommandEntry
+	models   map[[2]int]ModelEntry // keyed by family & model
+	clouds   map[string]bool       // the clouds whose mappings have been layered over
+}
+
+// a state map entry as it appears in the mapping files
+type stateSpec struct {
+	Name   string                 `json:"name" yaml:"name"`
+	Values map[string]interface{} `json:"values" yaml:"values"`
+	Codec  `yaml:",inline"`
+}
+
+// a command map entry as it appears in the mapping files
+type commandSpec struct {
+	Uid       int            `json:"uid" yaml:"uid"`
+	Values    map[string]int `json:"values" yaml:"values"`
+	Min       *float64       `json:"min" yaml:"min"`
+	Max       *float64       `json:"max" yaml:"max"`
+	WriteOnly bool           `json:"writeOnly" yaml:"writeOnly"`
+}
+
+// a user supplied mappings file, either section may be omitted
+type mappingsSpec struct {
+	State   map[string]stateSpec   `json:"state" yaml:"state"`
+	Command map[string]commandSpec `json:"command" yaml:"command"`
+	Model   []ModelEntry           `json:"model" yaml:"model"`
+}
+
+// an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		states:   make(map[int]StateEntry),
+		names:    make(map[string]int),
+		commands: make(map[string]CommandEntry),
+		models:   make(map[[2]int]ModelEntry),
+		clouds:   make(map[string]bool),
+	}
+}
+
+// the registry of the embedded mappings, which must always load
+func embeddedRegistry() *Registry {
+	var (
+		states   map[string]stateSpec
+		commands map[string]commandSpec
+		models   []ModelEntry
+	)
+	r := NewRegistry()
+	if err := json.Unmarshal(_stateMapJSON, &states); err != nil {
+		fmt.Printf("fatal! unable to load in the state map")
+		panic(err)
+	}
+	if err := json.Unmarshal(_commandMapJSON, &commands); err != nil {
+		fmt.Printf("fatal! unable to load in the command map")
+		panic(err)
+	}
+	if err := json.Unmarshal(_modelMapJSON, &models); err != nil {
+		fmt.Printf("fatal! unable to load in the model map")
+		panic(err)
+	}
+	if err := r.add(mappingsSpec{State: states, Command: commands, Model: models}); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// a copy of the registry, which further mappings can be layered over without touching it
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := NewRegistry()
+	for uid, e := range r.states {
+		c.states[uid] = e
+	}
+	for name, e := range r.commands {
+		c.commands[name] = e
+	}
+	for k, m := range r.models {
+		c.models[k] = m
+	}
+	for name := range r.clouds {
+		c.clouds[name] = true
+	}
+	c.index()
+	return c
+}
+
+// layers a mappings file over the registry, YAML or JSON with state, command & model sections
+// shaped like the embedded maps. entries replace any existing entry with the same uid, name or model
+func (r *Registry) Load(data []byte) error {
+	var spec mappingsSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return fmt.Errorf("malformed mappings: %w", err)
+	}
+	return r.add(spec)
+}
+
+// layers the mappings file at path over the registry
+func (r *Registry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = r.Load(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// a copy of DefaultRegistry with the mapping files layered over it, for WithRegistry
+// DefaultRegistry itself is untouched
+func LoadMappings(paths ...string) (*Registry, error) {
+	r := DefaultRegistry.Clone()
+	for _, p := range paths {
+		if err := r.LoadFile(p); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// validates every entry before applying any of them
+func (r *Registry) add(spec mappingsSpec) error {
+	states := make(map[int]StateEntry, len(spec.State))
+	for k, s := range spec.State {
+		uid, err := strconv.Atoi(k)
+		if err != nil {
+			return fmt.Errorf("state map uid is not a number: %s", k)
+		}
+		if s.Name == "" {
+			return fmt.Errorf("state map uid: %v has no name", uid)
+		}
+		e := StateEntry{Uid: uid, Name: s.Name, Codec: s.Codec}
+		if len(s.Values) > 0 {
+			e.Values = make(map[int]interface{}, len(s.Values))
+			e.raw = make(map[string]int, len(s.Values))
+			for rk, v := range s.Values {
+				raw, err := strconv.Atoi(rk)
+				if err != nil {
+					return fmt.Errorf("state map uid: %v has a value which is not a number: %s", uid, rk)
+				}
+				v = normalise(v)
+				e.Values[raw] = v
+				if name, ok := v.(string); ok {
+					e.raw[name] = raw
+				}
+			}
+		}
+		states[uid] = e
+	}
+	commands := make(map[string]CommandEntry, len(spec.Command))
+	for name, c := range spec.Command {
+		if c.Uid == 0 {
+			return fmt.Errorf("command map key: %v has no uid", name)
+		}
+		e := CommandEntry{Name: name, Uid: c.Uid, Values: c.Values, Min: c.Min, Max: c.Max, WriteOnly: c.WriteOnly}
+		e.names = make(map[int]string, len(c.Values))
+		for n, v := range c.Values {
+			e.names[v] = n
+		}
+		commands[name] = e
+	}
+	for _, m := range spec.Model {
+		if m.FamilyID == 0 && m.ModelID == 0 {
+			return fmt.Errorf("model map entry has no familyId or modelId")
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for uid, e := range states {
+		r.states[uid] = e
+	}
+	for name, e := range commands {
+		r.commands[name] = e
+	}
+	for _, m := range spec.Model {
+		r.models[[2]int{m.FamilyID, m.ModelID}] = m
+	}
+	r.index()
+	return nil
+}
+
+// rebuilds the name index, where uids share a name the lowest wins
+func (r *Registry) index() {
+	r.names = make(map[string]int, len(r.states))
+	for _, uid := range sortedUids(r.states) {
+		if _, ok := r.names[r.states[uid].Name]; !ok {
+			r.names[r.states[uid].Name] = uid
+		}
+	}
+}
+
+// YAML decodes maps with non string keys as map[interface{}]interface{}
+func normalise(v interface{}) interface{} {
+	switch m := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[fmt.Sprint(k)] = normalise(v)
+		}
+		return out
+	case map[string]interface{}:
+		for k, v := range m {
+			m[k] = normalise(v)
+		}
+	}
+	return v
+}
+
+// looks up a uid
+func (r *Registry) Uid(uid int) (e StateEntry, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok = r.states[uid]
+	return
+}
+
+// looks up a uid by its name
+func (r *Registry) Name(name string) (e StateEntry, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.name(name)
+}
+
+// looks up a uid by its name, the caller holds the lock
+func (r *Registry) name(name string) (e StateEntry, ok bool) {
+	uid, ok := r.names[name]
+	if !ok {
+		return
+	}
+	e, ok = r.states[uid]
+	return
+}
+
+// looks up a command by its name
+func (r *Registry) Command(name string) (e CommandEntry, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok = r.commands[name]
+	return
+}
+
+// whether the uid is only ever written by a write only command
+func (r *Registry) WriteOnly(uid int) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, e := range r.commands {
+		if e.Uid == uid && e.WriteOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// looks up a model by its family & model id
+func (r *Registry) Model(familyID, modelID int) (e ModelEntry, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok = r.models[[2]int{familyID, modelID}]
+	return
+}
+
+// every uid in ascending order
+func (r *Registry) States() (entries []StateEntry) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, uid := range sortedUids(r.states) {
+		entries = append(entries, r.states[uid])
+	}
+	return
+}
+
+func sortedUids(states map[int]StateEntry) []int {
+	uids := make([]int, 0, len(states))
+	for uid := range states {
+		uids = append(uids, uid)
+	}
+	sort.Ints(uids)
+	return uids
+}
+
+// the codec declared for the uid, the zero Codec passes raw values through untouched
+func (r *Registry) Codec(uid int) Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.states[uid].Codec
+}
+
+// for a given uid, decode it's real name as a string
+// if we are unable to then return the original uid as a string
+func (r *Registry) DecodeUid(uid int) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if e, ok := r.states[uid]; ok {
+		return e.Name
+	}
+	return fmt.Sprint(uid)
+}
+
+// see StateEntry.Decode, the original value if the name is unknown
+func (r *Registry) DecodeState(name string, value int) interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.name(name)
+	if !ok {
+		// couldn't find it give back the value
+		return value
+	}
+	return e.Decode(value)
+}
+
+// maps a command & its value onto the uid & raw value for the device
+// numeric values are in engineering units & encoded via the uid's codec, so setpoint 21.5 becomes 215
+// failures are ErrInvalidCommand
+func (r *Registry) MapCommand(key string, value interface{}) (uid, mValue int, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[key]
+	if !ok {
+		err = newError(ErrInvalidCommand, "key not present in command map: %s", key)
+		return
+	}
+	uid = cmd.Uid
+	codec := r.states[uid].Codec
+	switch v := value.(type) {
+	case float64:
+		mValue, err = cmd.encode(codec, v)
+	case int:
+		mValue, err = cmd.encode(codec, float64(v))
+	case string:
+		if f, perr := strconv.ParseFloat(v, 64); perr == nil {
+			// it's a number so encode it
+			mValue, err = cmd.encode(codec, f)
+			return
+		}
+		// otherwise we have to map it
+		if cmd.Values == nil {
+			err = newError(ErrInvalidCommand, "expected a number for command: %v got: %v", key, value)
+			return
+		}
+		if mValue, ok = cmd.Values[v]; !ok {
+			err = newError(ErrInvalidCommand, "no such value: %v exists for command: %v wanted: %v", value, key, cmd.Values)
+		}
+	default:
+		err = newError(ErrInvalidCommand, "bad conversion type, expected float / int / string got: %s", reflect.TypeOf(value))
+	}
+	return
+}
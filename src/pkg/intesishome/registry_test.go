@@ -0,0 +1,176 @@
+package intesishome
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMappingOverlay string = "./assets/tests/mappingOverlay.yaml"
+
+func TestRegistry(t *testing.T) {
+	r := embeddedRegistry()
+	t.Run("indexed by uid & name", func(t *testing.T) {
+		e, ok := r.Uid(2)
+		assert.True(t, ok)
+		assert.Equal(t, "mode", e.Name)
+		byName, ok := r.Name("mode")
+		assert.True(t, ok)
+		assert.Equal(t, e.Uid, byName.Uid)
+		_, ok = r.Uid(65535)
+		assert.False(t, ok)
+	})
+	t.Run("enum values both ways", func(t *testing.T) {
+		e, _ := r.Name("mode")
+		assert.Equal(t, "cool", e.Decode(4))
+		raw, ok := e.Raw("cool")
+		assert.True(t, ok)
+		assert.Equal(t, 4, raw)
+		c, ok := r.Command("mode")
+		assert.True(t, ok)
+		name, ok := c.ValueName(4)
+		assert.True(t, ok)
+		assert.Equal(t, "cool", name)
+	})
+	t.Run("shared names resolve to the lowest uid", func(t *testing.T) {
+		e, _ := r.Name("error_code")
+		assert.Equal(t, 15, e.Uid)
+	})
+	t.Run("heat temperature limits are distinct", func(t *testing.T) {
+		assert.Equal(t, "heat_temperature_min", r.DecodeUid(50006))
+		assert.Equal(t, "heat_temperature_max", r.DecodeUid(50007))
+	})
+	t.Run("model table", func(t *testing.T) {
+		m, ok := r.Model(3840, 79)
+		assert.True(t, ok)
+		assert.Equal(t, 31, m.Config["config_fan_map"])
+		_, ok = r.Model(3840, 1)
+		assert.False(t, ok)
+	})
+	t.Run("named values", func(t *testing.T) {
+		for name, named := range map[string]bool{
+			"mode":          true,
+			"quiet_mode":    true,
+			"fan_speed":     true, // via the profile
+			"heat_interval": false,
+			"temperature":   false,
+			"rssi":          false,
+		} {
+			e, _ := r.Name(name)
+			assert.Equal(t, named, e.Named(), name)
+		}
+	})
+	t.Run("states are ordered", func(t *testing.T) {
+		states := r.States()
+		assert.Equal(t, 1, states[0].Uid)
+		assert.Equal(t, 60002, states[len(states)-1].Uid)
+	})
+}
+
+func TestRegistryLoad(t *testing.T) {
+	t.Run("overlay file", func(t *testing.T) {
+		r := embeddedRegistry()
+		assert.NoError(t, r.LoadFile(testMappingOverlay))
+		assert.Equal(t, "night_mode", r.DecodeUid(200))
+		assert.Equal(t, "on", r.DecodeState("night_mode", 1))
+		uid, v, err := r.MapCommand("night_mode", "on")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, uid)
+		assert.Equal(t, 1, v)
+		assert.Equal(t, map[string]interface{}{"1": "low", "2": "high"}, r.DecodeState("fan_profiles", 3))
+		// replaced wholesale, so the setpoint is no longer signed
+		assert.Equal(t, Codec{Scale: 10, Unit: "celsius"}, r.Codec(9))
+		m, ok := r.Model(3840, 81)
+		assert.True(t, ok)
+		assert.Equal(t, map[string]int{"config_fan_map": 7}, m.Config)
+		// everything else is untouched
+		assert.Equal(t, "cool", r.DecodeState("mode", 4))
+	})
+	t.Run("json overlay", func(t *testing.T) {
+		r := embeddedRegistry()
+		assert.NoError(t, r.Load([]byte(`{"state": {"300": {"name": "purifier", "values": {"0": "off", "1": "on"}}}}`)))
+		assert.Equal(t, "off", r.DecodeState("purifier", 0))
+	})
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"malformed", `state: [`, "malformed mappings"},
+		{"uid which isn't a number", `{"state": {"x": {"name": "x"}}}`, "uid is not a number: x"},
+		{"uid without a name", `{"state": {"300": {}}}`, "uid: 300 has no name"},
+		{"value which isn't a number", `{"state": {"300": {"name": "x", "values": {"on": "on"}}}}`, "value which is not a number: on"},
+		{"command without a uid", `{"command": {"x": {}}}`, "key: x has no uid"},
+		{"model without ids", `{"model": [{"config": {"config_fan_map": 7}}]}`, "no familyId or modelId"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := embeddedRegistry()
+			err := r.Load([]byte(`{"state": {"400": {"name": "applied"}}}`))
+			assert.NoError(t, err)
+			err = r.Load([]byte(tt.data))
+			assert.ErrorContains(t, err, tt.err)
+			assert.Equal(t, "applied", r.DecodeUid(400))
+		})
+	}
+	t.Run("a failed load applies nothing", func(t *testing.T) {
+		r := embeddedRegistry()
+		err := r.Load([]byte(`{"state": {"500": {"name": "partial"}}, "command": {"x": {}}}`))
+		assert.Error(t, err)
+		assert.Equal(t, "500", r.DecodeUid(500))
+	})
+	t.Run("missing file", func(t *testing.T) {
+		assert.Error(t, embeddedRegistry().LoadFile("./assets/tests/nonexistent.yaml"))
+	})
+}
+
+func TestLoadMappings(t *testing.T) {
+	r, err := LoadMappings(testMappingOverlay)
+	assert.NoError(t, err)
+	assert.NotSame(t, DefaultRegistry, r)
+	assert.Equal(t, "night_mode", r.DecodeUid(200))
+	// DefaultRegistry is left alone
+	assert.Equal(t, "200", DefaultRegistry.DecodeUid(200))
+	_, err = LoadMappings("./assets/tests/nonexistent.yaml")
+	assert.Error(t, err)
+}
+
+func TestClientRegistry(t *testing.T) {
+	t.Run("DefaultRegistry unless given", func(t *testing.T) {
+		assert.Same(t, DefaultRegistry, New("user", "pass").Registry())
+		r := embeddedRegistry()
+		assert.Same(t, r, New("user", "pass", WithRegistry(r)).Registry())
+	})
+	t.Run("mappings are the client's own", func(t *testing.T) {
+		ih := New("user", "pass", WithMappings(testMappingOverlay))
+		assert.Equal(t, "night_mode", ih.Registry().DecodeUid(200))
+		assert.Equal(t, "200", New("user", "pass").Registry().DecodeUid(200))
+		assert.Equal(t, "200", DefaultRegistry.DecodeUid(200))
+	})
+	t.Run("mappings which don't load fail every call", func(t *testing.T) {
+		ih := New("user", "pass", WithMappings("./assets/tests/nonexistent.yaml"))
+		_, err := ih.StatusAll()
+		assert.ErrorContains(t, err, "unable to load mappings")
+		assert.ErrorContains(t, ih.Set(127934703953, 1, 1), "unable to load mappings")
+	})
+	t.Run("loads alongside lookups", func(t *testing.T) {
+		r := embeddedRegistry()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, r.Load([]byte(fmt.Sprintf(`{"state": {"%d": {"name": "x%d"}}}`, 300+i, i))))
+			}(i)
+			go func() {
+				defer wg.Done()
+				r.DecodeUid(1)
+				r.States()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, "x9", r.DecodeUid(309))
+	})
+}
@@ -1,7 +1,7 @@
 package intesishome
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,46 +15,45 @@ import (
 const (
 	DefaultHostname    string        = "https://user.intesishome.com"
 	ControlEndpoint    string        = "/api.php/get/control"
-	_statusCommand     string        = `{"status":{"hash":"x"},"config":{"hash":"x"}}`
-	_statusVersion     string        = "1.8.5"
-	_readLimitBytes    int           = 1024
+	_statusCommand     string        = `{"status":{"hash":"%s"},"config":{"hash":"%s"}}`
+	_noHash            string        = "x" // asks for the whole section
 	_socketReadTimeout time.Duration = 30 * time.Second
 )
 
 type ControlResponse struct {
 	Config struct {
-		Token          int     `json:"token"`
-		PushToken      string  `json:"pushToken"`
-		LastAppVersion string  `json:"lastAppVersion"`
-		ForceUpdate    int     `json:"forceUpdate"`
-		SetDelay       float64 `json:"setDelay"`
-		ServerIP       string  `json:"serverIP"`
-		ServerPort     int     `json:"serverPort"`
-		Hash           string  `json:"hash"`
-		Inst           []struct {
-			ID      int      `json:"id"`
-			Order   int      `json:"order"`
-			Name    string   `json:"name"`
-			Devices []Device `json:"devices"`
-		} `json:"inst"`
+		Token          int            `json:"token"`
+		PushToken      string         `json:"pushToken"`
+		LastAppVersion string         `json:"lastAppVersion"`
+		ForceUpdate    int            `json:"forceUpdate"`
+		SetDelay       float64        `json:"setDelay"`
+		ServerIP       string         `json:"serverIP"`
+		ServerPort     int            `json:"serverPort"`
+		Hash           string         `json:"hash"`
+		Inst           []Installation `json:"inst"`
 	} `json:"config"`
 	Status struct {
-		Hash   string `json:"hash"`
-		Status []struct {
-			DeviceID int64 `json:"deviceId"`
-			UID      int   `json:"uid"`
-			Value    int   `json:"value"`
-			Name     string
-		} `json:"status"`
+		Hash   string        `json:"hash"`
+		Status []StatusValue `json:"status"`
 	} `json:"status"`
-	ErrorCode    int    `json:"errorCode"`
-	ErrorMessage string `json:"errorMessage"`
+	ErrorCode     int    `json:"errorCode"`
+	ErrorMessage  string `json:"errorMessage"`
+	ConfigChanged bool   `json:"-"` // whether the config differs from the previous response
+	StatusChanged bool   `json:"-"` // whether the status differs from the previous response
+}
+
+// the raw value of a uid on a device
+type StatusValue struct {
+	DeviceID int64 `json:"deviceId"`
+	UID      int   `json:"uid"`
+	Value    int   `json:"value"`
+	Name     string
 }
 
 type CommandResponse struct {
 	Command string `json:"command"`
 	Data    struct {
-		DeviceID int64  `json:"devceId,omitempty"`
+		DeviceID int64  `json:"deviceId,omitempty"`
 		SeqNo    int    `json:"seqNo,omitempty"`
 		Rssi     int    `json:"rssi,omitempty"`
 		Status   string `json:"status,omitempty"`
@@ -74,39 +73,65 @@ type CommandRequestData struct {
 	Token    int   `json:"token"`
 }
 
-func controlRequest(ih *IntesisHome) (r ControlResponse, err error) {
+// polls the control endpoint, sending back the hashes from the previous response so that
+// the cloud can skip sections which haven't changed. skipped sections are filled in from
+// the previous response. full asks for everything, which is needed to get a fresh token
+func controlRequest(ctx context.Context, ih *IntesisHome, full bool) (r ControlResponse, err error) {
 	ih.mu.Lock()
 	defer ih.mu.Unlock()
-	form := statusForm(ih.username, ih.password)
-	uri := ih.hostname + ControlEndpoint
-	resp, err := http.PostForm(uri, form)
+	defer func(start time.Time) { ih.observe().ObserveRequest(time.Since(start), err) }(time.Now())
+	configHash, statusHash := _noHash, _noHash
+	if !full {
+		configHash, statusHash = hashOrNone(ih.last.Config.Hash), hashOrNone(ih.last.Status.Hash)
+	}
+	form := statusForm(ih.username, ih.password, ih.cloud.Version, configHash, statusHash)
+	uri := ih.baseURL() + ControlEndpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := ih.client.Do(req)
 	if err != nil {
+		err = networkError(err)
 		return
 	}
 	body, err := io.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
+		err = networkError(err)
 		return
 	}
 	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("unexpected response code: %v body: %s", resp.StatusCode, body)
+		err = &HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 		return
 	}
 	if len(body) < 10 {
-		err = fmt.Errorf("unexpected response body: %s", string(body))
+		err = newError(ErrProtocol, "unexpected response body: %s", string(body))
 		return
 	}
 	if err = json.Unmarshal(body, &r); err != nil {
-		err = fmt.Errorf("malformed payload: %v", err.Error())
+		err = newError(ErrProtocol, "malformed payload: %v", err.Error())
 		return
 	}
 	if r.ErrorCode != 0 {
-		err = fmt.Errorf("unexpected response error: %v message: %v", r.ErrorCode, r.ErrorMessage)
+		err = &CloudError{Code: r.ErrorCode, Message: r.ErrorMessage}
+		return
+	}
+	configFresh := mergeControl(&r, &ih.last, configHash, statusHash)
+	ih.last = r
+	if !configFresh {
+		if ih.verbose {
+			fmt.Printf("DEBUG|controlRequest| config unchanged: %v status changed: %v\n", r.Config.Hash, r.StatusChanged)
+		}
 		return
 	}
 	ih.token = r.Config.Token
 	ih.serverIP = r.Config.ServerIP
 	ih.serverPort = r.Config.ServerPort
+	if !ih.fixedDelay {
+		ih.setDelay = time.Duration(r.Config.SetDelay * float64(time.Second))
+	}
 	// override the TCPServer settings
 	if ih.tcpServer != "" {
 		addr := strings.Split(ih.tcpServer, ":")
@@ -122,42 +147,69 @@ func controlRequest(ih *IntesisHome) (r ControlResponse, err error) {
 	return r, err
 }
 
-// TODO: debug why we are getting EOF on the read here & there
-func socketWriteRead(ih *IntesisHome, b []byte) (response []byte, err error) {
-	if ih.cmdSocket == nil {
-		err = fmt.Errorf("tcp socket was nil?")
-		return
+// fills in the sections of r which the cloud skipped, because their hash matched the one
+// sent, from the previous response & flags which sections have changed since it. a changed
+// status only carries the entries which changed, unless everything was asked for
+// returns whether the config section (& so the token) came from the cloud this time
+func mergeControl(r, last *ControlResponse, configHash, statusHash string) (configFresh bool) {
+	configFresh = !skipped(configHash, r.Config.Hash, len(r.Config.Inst))
+	if !configFresh {
+		token := r.Config.Token
+		r.Config = last.Config
+		if token != 0 {
+			r.Config.Token = token
+		}
 	}
-	if ih.verbose {
-		fmt.Printf("DEBUG|socketWrite| sending request: %s\n", string(b))
+	if skipped(statusHash, r.Status.Hash, len(r.Status.Status)) {
+		r.Status = last.Status
+	} else if statusHash != _noHash {
+		r.Status.Status = mergeStatus(last.Status.Status, r.Status.Status)
 	}
-	wBytes, err := ih.cmdSocket.Write(b)
-	if err != nil {
-		err = fmt.Errorf("socket write error: %v", err)
-		return
+	r.ConfigChanged = r.Config.Hash != last.Config.Hash
+	r.StatusChanged = r.Status.Hash != last.Status.Hash
+	return
+}
+
+// the previous status with the changed entries applied over it by device & uid
+func mergeStatus(last, changed []StatusValue) []StatusValue {
+	type key struct {
+		device int64
+		uid    int
 	}
-	if wBytes != len(b) {
-		err = fmt.Errorf("write byte mismatch, expected: %v actual: %v", wBytes, len(b))
-		return
+	merged := make([]StatusValue, len(last), len(last)+len(changed))
+	copy(merged, last)
+	index := make(map[key]int, len(merged))
+	for i, v := range merged {
+		index[key{v.DeviceID, v.UID}] = i
 	}
-	response = make([]byte, _readLimitBytes)
-	_, err = ih.cmdSocket.Read(response)
-	if err != nil {
-		err = fmt.Errorf("socket read error: %v", err)
-		return
+	for _, v := range changed {
+		if i, ok := index[key{v.DeviceID, v.UID}]; ok {
+			merged[i] = v
+			continue
+		}
+		index[key{v.DeviceID, v.UID}] = len(merged)
+		merged = append(merged, v)
 	}
-	if ih.verbose {
-		fmt.Printf("DEBUG|socketWrite| received response: %s\n", response)
+	return merged
+}
+
+// whether the cloud left out a section's content because it was unchanged
+func skipped(sent, received string, entries int) bool {
+	return sent != _noHash && entries == 0 && (received == "" || received == sent)
+}
+
+func hashOrNone(hash string) string {
+	if hash == "" {
+		return _noHash
 	}
-	response = bytes.Trim(response, "\x00") // trim any nulls from the end
-	return
+	return hash
 }
 
-func statusForm(user, pass string) (ret url.Values) {
+func statusForm(user, pass, version, configHash, statusHash string) (ret url.Values) {
 	ret = url.Values{}
 	ret.Set("username", user)
 	ret.Add("password", pass)
-	ret.Add("version", _statusVersion)
-	ret.Add("cmd", _statusCommand)
+	ret.Add("version", version)
+	ret.Add("cmd", fmt.Sprintf(_statusCommand, statusHash, configHash))
 	return
 }
@@ -1,61 +1,16 @@
 package intesishome
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"net"
 	"net/http"
-	"strconv"
+	"net/http/httptest"
+	"os"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-const (
-	_testValidToken   int    = 12345
-	_testAuthRequest  string = `{"command":"connect_req","data":{"deviceId":0,"uid":0,"value":0,"seqNo":0,"token":12345}}`
-	_testAuthResponse string = `{"command":"connect_rsp","data":{"status":"ok"}}`
-	_testAuthFailure  string = `{"command":"connect_rsp","data":{"status":"err_token"}}`
-	_testAuthInvalid  string = `{"command":"garbage","data":{"status":"ok"}}`
-	_testSetRequest   string = `{"command":"set","data":{"deviceId":127934703953,"uid":1,"value":0,"seqNo":0,"token":0}}`
-	_testSetResponse  string = `{"command":"set_ack","data":{"deviceId":127934703953,"seqNo":85,"rssi":198}}`
-	_testSetInvalid   string = `{"command":"garbage","data":{"deviceId":127934703953,"seqNo":85,"rssi":198}}`
-)
-
-type mockConn struct {
-	ReadFunc  ReadWriteFunc
-	WriteFunc ReadWriteFunc
-	PostAuth  bool
-	T         *testing.T
-}
-
-type ReadWriteFunc func(*mockConn, []byte) (int, error)
-
-func newMockConn(reader, writer ReadWriteFunc, t *testing.T) *mockConn {
-	c := mockConn{
-		ReadFunc:  reader,
-		WriteFunc: writer,
-		PostAuth:  false,
-		T:         t,
-	}
-	return &c
-}
-func (c *mockConn) Read(b []byte) (n int, err error) {
-	n, err = c.ReadFunc(c, b)
-	return
-}
-func (c *mockConn) Write(b []byte) (n int, err error) {
-	n, err = c.WriteFunc(c, b)
-	return
-}
-func (c *mockConn) Close() error                       { return nil }
-func (c *mockConn) LocalAddr() net.Addr                { return nil }
-func (c *mockConn) RemoteAddr() net.Addr               { return nil }
-func (c *mockConn) SetDeadline(t time.Time) error      { return nil }
-func (c *mockConn) SetReadDeadline(t time.Time) error  { return nil }
-func (c *mockConn) SetWriteDeadline(t time.Time) error { return nil }
-
 type inlineControlCheck func(t *testing.T, r *ControlResponse, e error)
 
 func TestAPICalls(t *testing.T) {
@@ -71,6 +26,10 @@ func TestAPICalls(t *testing.T) {
 			testNilResponsePayload,
 			func(t *testing.T, r *ControlResponse, e error) {
 				assert.ErrorContains(t, e, "unexpected response code")
+				assert.ErrorIs(t, e, ErrUnreachable)
+				var he *HTTPError
+				assert.ErrorAs(t, e, &he)
+				assert.Equal(t, http.StatusBadGateway, he.StatusCode)
 			},
 		},
 		{
@@ -79,6 +38,7 @@ func TestAPICalls(t *testing.T) {
 			testNilResponsePayload,
 			func(t *testing.T, r *ControlResponse, e error) {
 				assert.ErrorContains(t, e, "unexpected response body")
+				assert.ErrorIs(t, e, ErrProtocol)
 			},
 		},
 		{
@@ -87,6 +47,10 @@ func TestAPICalls(t *testing.T) {
 			testErrorControlResponsePayload,
 			func(t *testing.T, r *ControlResponse, e error) {
 				assert.ErrorContains(t, e, "unexpected response error")
+				var ce *CloudError
+				assert.ErrorAs(t, e, &ce)
+				assert.Equal(t, 1, ce.Code)
+				assert.Equal(t, "WRONG_METHOD", ce.Message)
 			},
 		},
 		{
@@ -95,6 +59,7 @@ func TestAPICalls(t *testing.T) {
 			testMalformedResponsePayload,
 			func(t *testing.T, r *ControlResponse, e error) {
 				assert.ErrorContains(t, e, "malformed payload")
+				assert.ErrorIs(t, e, ErrProtocol)
 			},
 		},
 		{
@@ -116,156 +81,6 @@ func TestAPICalls(t *testing.T) {
 	}
 }
 
-func TestSocketCalls(t *testing.T) {
-	t.Run("invalid endpoint", func(t *testing.T) {
-		var req []byte
-		ih := IntesisHome{}
-		ih.cmdSocket = nil
-		_, err := socketWriteRead(&ih, req)
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "tcp socket was nil")
-	})
-	t.Run("write EOF", func(t *testing.T) {
-		var req []byte
-		ih := IntesisHome{}
-		reader := func(*mockConn, []byte) (int, error) {
-			return 0, nil
-		}
-		writer := func(*mockConn, []byte) (int, error) {
-			return 0, errors.New("EOF")
-		}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		_, err := socketWriteRead(&ih, req)
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "socket write error")
-	})
-	t.Run("read EOF", func(t *testing.T) {
-		ih := IntesisHome{}
-		reader := func(*mockConn, []byte) (int, error) {
-			return 0, errors.New("EOF")
-		}
-		writer := func(c *mockConn, b []byte) (int, error) {
-			return len(b), nil
-		}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		_, err := socketWriteRead(&ih, []byte(_testAuthRequest))
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "socket read error")
-	})
-	t.Run("write mismatch", func(t *testing.T) {
-		ih := IntesisHome{}
-		reader := func(*mockConn, []byte) (int, error) {
-			return 0, nil
-		}
-		writer := func(c *mockConn, b []byte) (int, error) {
-			return len(b) + 10, nil
-		}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		_, err := socketWriteRead(&ih, []byte("string"))
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "write byte mismatch")
-	})
-}
-
-// scenarios: bad response payloads, unexpected payloads, EOF, timeout socket close, success
-func TestSetHandler(t *testing.T) {
-	t.Run("valid set response", func(t *testing.T) {
-		reader := func(c *mockConn, b []byte) (int, error) {
-			if !c.PostAuth {
-				c.PostAuth = true // auth is complete, now progress to the set
-				copy(b, []byte(_testAuthResponse))
-				return len([]byte(_testAuthResponse)), nil
-			}
-			copy(b, []byte(_testSetResponse))
-			return len([]byte(_testSetResponse)), nil
-		}
-		writer := func(c *mockConn, b []byte) (int, error) {
-			if !c.PostAuth {
-				assert.Equal(c.T, _testAuthRequest, string(b))
-				return len([]byte(_testAuthRequest)), nil
-			}
-			assert.Equal(c.T, _testSetRequest, string(b))
-			return len([]byte(_testSetRequest)), nil
-		}
-		ih := IntesisHome{}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		ih.token = _testValidToken
-		d, _ := strconv.ParseInt(testDeviceId, 0, 64)
-		err := setHandler(&ih, int64(d), 1 /* power */, 0 /* off */)
-		assert.NoError(t, err)
-	})
-	t.Run("invalid auth token", func(t *testing.T) {
-		reader := func(c *mockConn, b []byte) (int, error) {
-			copy(b, []byte(_testAuthFailure))
-			return len([]byte(_testAuthFailure)), nil
-		}
-		writer := func(c *mockConn, b []byte) (int, error) {
-			assert.Equal(c.T, _testAuthRequest, string(b))
-			return len([]byte(_testAuthRequest)), nil
-		}
-		ih := IntesisHome{}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		ih.token = _testValidToken
-		d, _ := strconv.ParseInt(testDeviceId, 10, 64)
-		err := setHandler(&ih, int64(d), 1 /* power */, 0 /* off */)
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "unexpected auth reply.")
-		assert.ErrorContains(t, err, "expected: ok got: err_token")
-	})
-	t.Run("invalid auth response", func(t *testing.T) {
-		reader := func(c *mockConn, b []byte) (int, error) {
-			copy(b, []byte(_testAuthInvalid))
-			return len([]byte(_testAuthInvalid)), nil
-		}
-		writer := func(c *mockConn, b []byte) (int, error) {
-			assert.Equal(c.T, _testAuthRequest, string(b))
-			return len([]byte(_testAuthRequest)), nil
-		}
-		ih := IntesisHome{}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		ih.token = _testValidToken
-		d, _ := strconv.ParseInt(testDeviceId, 10, 64)
-		err := setHandler(&ih, int64(d), 1 /* power */, 0 /* off */)
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "unexpected auth reply.")
-		assert.ErrorContains(t, err, "expected: connect_rsp got: garbage")
-	})
-	t.Run("invalid set", func(t *testing.T) {
-		reader := func(c *mockConn, b []byte) (int, error) {
-			if !c.PostAuth {
-				c.PostAuth = true // auth is complete, now progress to the set
-				copy(b, []byte(_testAuthResponse))
-				return len([]byte(_testAuthResponse)), nil
-			}
-			copy(b, []byte(_testSetInvalid))
-			return len([]byte(_testSetInvalid)), nil
-		}
-		writer := func(c *mockConn, b []byte) (int, error) {
-			if !c.PostAuth {
-				assert.Equal(c.T, _testAuthRequest, string(b))
-				return len([]byte(_testAuthRequest)), nil
-			}
-			assert.Equal(c.T, _testSetRequest, string(b))
-			return len([]byte(_testSetRequest)), nil
-		}
-		ih := IntesisHome{}
-		c := newMockConn(reader, writer, t)
-		ih.cmdSocket = c
-		ih.token = _testValidToken
-		d, _ := strconv.ParseInt(testDeviceId, 10, 64)
-		err := setHandler(&ih, int64(d), 1 /* power */, 0 /* off */)
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "set command failed.")
-		assert.ErrorContains(t, err, "expected: set_ack got: garbage")
-	})
-}
-
 func testControlRequest(responseCode int, payload string) (r ControlResponse, err error) {
 	s, err := mockHTTPServer(responseCode, payload)
 	if err != nil {
@@ -273,6 +88,61 @@ func testControlRequest(responseCode int, payload string) (r ControlResponse, er
 		return
 	}
 	ih := New("u", "p", WithHostname(s.URL))
-	r, err = controlRequest(ih)
+	r, err = controlRequest(context.Background(), ih, false)
 	return
 }
+
+func TestConditionalPolling(t *testing.T) {
+	const (
+		configHash string = "1ea07777a7c19adb6f8e41ac340a8f723797d9cc"
+		statusHash string = "0f037a715b304d8a41089bdceb9f45d8af0aa2f7"
+	)
+	full, err := os.ReadFile(testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("unable to read payload: %v", err)
+	}
+	unchanged := fmt.Sprintf(`{"config":{"hash":"%s"},"status":{"hash":"%s"}}`, configHash, statusHash)
+	changed := fmt.Sprintf(`{"config":{"hash":"%s"},"status":{"hash":"new","status":[{"deviceId":127934703953,"uid":1,"value":0}]}}`, configHash)
+	var cmds []string
+	body := string(full)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cmds = append(cmds, r.FormValue("cmd"))
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+	ih := New("u", "p", WithHostname(s.URL))
+
+	r, err := controlRequest(context.Background(), ih, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status":{"hash":"x"},"config":{"hash":"x"}}`, cmds[0])
+	assert.True(t, r.ConfigChanged)
+	assert.True(t, r.StatusChanged)
+
+	body = unchanged
+	r, err = controlRequest(context.Background(), ih, false)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`{"status":{"hash":"%s"},"config":{"hash":"%s"}}`, statusHash, configHash), cmds[1])
+	assert.False(t, r.ConfigChanged)
+	assert.False(t, r.StatusChanged)
+	assert.Equal(t, testDeviceId, r.Config.Inst[0].Devices[0].ID)
+	assert.Equal(t, 41, len(r.Status.Status))
+	assert.Equal(t, 12345, r.Config.Token)
+
+	body = changed
+	r, err = controlRequest(context.Background(), ih, false)
+	assert.NoError(t, err)
+	assert.False(t, r.ConfigChanged)
+	assert.True(t, r.StatusChanged)
+	assert.Equal(t, "new", r.Status.Hash)
+	// only the changed uid is replaced, the untouched ones survive
+	assert.Equal(t, 41, len(r.Status.Status))
+	assert.Contains(t, r.Status.Status, StatusValue{DeviceID: 127934703953, UID: 1, Value: 0})
+	assert.Contains(t, r.Status.Status, StatusValue{DeviceID: 127934703953, UID: 10, Value: 210})
+	assert.Equal(t, testDeviceId, r.Config.Inst[0].Devices[0].ID)
+
+	// a fresh token needs the whole config
+	body = string(full)
+	_, err = controlRequest(context.Background(), ih, true)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"status":{"hash":"x"},"config":{"hash":"x"}}`, cmds[3])
+}
@@ -0,0 +1,91 @@
+package intesishome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// how failed control requests & sets are retried
+type RetryPolicy struct {
+	MaxAttempts    int              // attempts in total, 1 disables retries
+	InitialBackoff time.Duration    // the wait after the first failure, doubled after each further failure
+	MaxBackoff     time.Duration    // the ceiling for the wait
+	Jitter         float64          // the wait is randomised by up to this fraction either way
+	Retryable      func(error) bool // whether a failure is worth retrying
+}
+
+// retries transient failures a couple of times over a few seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 1 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.2,
+	Retryable:      Retryable,
+}
+
+// sets how failed control requests & sets are retried
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(ih *IntesisHome) {
+		ih.retry = p
+	}
+}
+
+// a failure which happened after the gateway acknowledged the set, the set may have
+// been applied so it's never retried regardless of the policy
+type acknowledgedError struct {
+	err error
+}
+
+func (e *acknowledgedError) Error() string { return e.err.Error() }
+func (e *acknowledgedError) Unwrap() error { return e.err }
+
+// whether the failure is worth another attempt under the policy
+func (p RetryPolicy) shouldRetry(err error) bool {
+	var ack *acknowledgedError
+	if errors.As(err, &ack) {
+		return false
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = Retryable
+	}
+	return retryable(err)
+}
+
+// the wait before the given retry, attempt being the one which just failed
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 - p.Jitter + 2*p.Jitter*rand.Float64()))
+	}
+	return d
+}
+
+// calls fn until it succeeds, fails with something not worth retrying, runs
+// out of attempts or ctx is done
+func (p RetryPolicy) do(ctx context.Context, verbose bool, fn func() error) (err error) {
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return
+		}
+		if attempt >= p.MaxAttempts || !p.shouldRetry(err) {
+			return
+		}
+		wait := p.backoff(attempt)
+		if verbose {
+			fmt.Printf("DEBUG|retry| attempt %v failed, retrying in %v: %v\n", attempt, wait, err)
+		}
+		if !sleepCtx(ctx, wait) {
+			return
+		}
+	}
+}
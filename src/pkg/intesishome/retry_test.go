@@ -0,0 +1,79 @@
+package intesishome
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, p.backoff(1))
+	assert.Equal(t, 2*time.Second, p.backoff(2))
+	assert.Equal(t, 4*time.Second, p.backoff(3))
+	assert.Equal(t, 5*time.Second, p.backoff(4))
+	assert.Equal(t, 5*time.Second, p.backoff(40))
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+		assert.LessOrEqual(t, d, 1500*time.Millisecond)
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"transient failure", []error{ErrUnreachable, ErrTimeout, nil}, 3, false},
+		{"out of attempts", []error{ErrUnreachable, ErrUnreachable, ErrUnreachable, nil}, 3, true},
+		{"not retryable", []error{ErrProtocol, nil}, 1, true},
+		{"already acknowledged", []error{&acknowledgedError{ErrTimeout}, nil}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := p.do(context.Background(), false, func() error {
+				attempts++
+				return tt.errs[attempts-1]
+			})
+			assert.Equal(t, tt.attempts, attempts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+	t.Run("custom classification", func(t *testing.T) {
+		boom := errors.New("boom")
+		p := RetryPolicy{MaxAttempts: 2, Retryable: func(err error) bool { return err == boom }}
+		attempts := 0
+		err := p.do(context.Background(), false, func() error {
+			attempts++
+			return boom
+		})
+		assert.ErrorIs(t, err, boom)
+		assert.Equal(t, 2, attempts)
+	})
+	t.Run("stops once the context is done", func(t *testing.T) {
+		p := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		attempts := 0
+		err := p.do(ctx, false, func() error {
+			attempts++
+			return ErrUnreachable
+		})
+		assert.ErrorIs(t, err, ErrUnreachable)
+		assert.Equal(t, 1, attempts)
+	})
+}
@@ -0,0 +1,499 @@
+package intesishome
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultKeepalive  time.Duration = 240 * time.Second
+	commandReqGet     string        = "get"
+	commandPushStatus string        = "status"
+	commandPushRssi   string        = "rssi"
+	_keepaliveUid     int           = 10 // temperature, the same uid pyIntesisHome polls
+)
+
+var errSessionClosed = newError(ErrUnreachable, "session closed")
+
+// a long lived & authenticated connection to the Intesis Home TCP gateway
+// a single reader pulls frames off the socket & hands replies back to
+// whichever exchange is waiting, which lets every Set share the one socket
+// set acks are matched to their set so several can be in flight, by sequence number
+// when the gateway echoes it & otherwise by device, oldest set first
+type session struct {
+	ih      *IntesisHome
+	conn    net.Conn
+	device  int64 // the device the keepalive is addressed to
+	replies chan CommandResponse
+	waiting int32              // set while an exchange is waiting on replies
+	pending map[int]pendingSet // sets awaiting their ack, by sequence number
+	pmu     sync.Mutex
+	done    chan struct{} // closed once the session is unusable
+	err     error         // the reason the session was shut down
+	mu      sync.Mutex    // serialises writes & request / reply exchanges
+	once    sync.Once
+}
+
+// the gateway's acknowledgement of a set
+type Ack struct {
+	DeviceID int64 `json:"deviceId"`
+	SeqNo    int   `json:"seqNo"`
+	Rssi     int   `json:"rssi"`
+}
+
+// a set which has been written & is waiting on its ack
+type pendingSet struct {
+	cmd  *CommandRequest
+	ack  chan CommandResponse
+	sent time.Time
+}
+
+// wraps an established connection & starts reading from it
+func newSession(ih *IntesisHome, conn net.Conn, device int64) *session {
+	s := &session{
+		ih:      ih,
+		conn:    conn,
+		device:  device,
+		replies: make(chan CommandResponse, 1),
+		pending: make(map[int]pendingSet),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+// returns a connected & authenticated session, reusing the existing one if it's still alive
+// a new session requires a fresh token, since the token is consumed on connect_req, so it's
+// refreshed via the control endpoint. callers retry according to the RetryPolicy
+// ctx only bounds establishing the session, not how long it then lives for
+func (ih *IntesisHome) session(ctx context.Context) (s *session, err error) {
+	ih.sessMu.Lock()
+	defer ih.sessMu.Unlock()
+	if ih.sess != nil && ih.sess.alive() {
+		return ih.sess, nil
+	}
+	if s, err = dialSession(ctx, ih); err != nil {
+		return
+	}
+	ih.sess = s
+	return
+}
+
+// dials the TCP gateway & authenticates using a freshly obtained token
+func dialSession(ctx context.Context, ih *IntesisHome) (s *session, err error) {
+	r, err := controlRequest(ctx, ih, true)
+	ih.observe().ObserveTokenRefresh(err)
+	if err != nil {
+		return
+	}
+	token := r.Config.Token
+	ih.mu.Lock()
+	addr := fmt.Sprintf("%s:%v", ih.serverIP, ih.serverPort)
+	if ih.token == token {
+		ih.token = 0 // consume the token
+	}
+	ih.mu.Unlock()
+	conn, err := ih.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		err = networkError(err)
+		return
+	}
+	s = newSession(ih, conn, firstDevice(r))
+	if err = s.authenticate(ctx, token); err != nil {
+		s.close()
+		s = nil
+		return
+	}
+	if ih.keepalive > 0 {
+		go s.keepalive(ih.keepalive)
+	}
+	return
+}
+
+// the first device from the control response, used as the keepalive target
+func firstDevice(r ControlResponse) int64 {
+	for _, inst := range r.Config.Inst {
+		for _, d := range inst.Devices {
+			if id, err := strconv.ParseInt(d.ID, 10, 64); err == nil {
+				return id
+			}
+		}
+	}
+	return 0
+}
+
+// whether the session can still be used
+func (s *session) alive() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// tears down the session, only the first cause is recorded
+func (s *session) shutdown(cause error) {
+	s.once.Do(func() {
+		s.err = cause
+		close(s.done)
+		s.conn.Close()
+		if s.ih.verbose {
+			fmt.Printf("DEBUG|session| closed: %v\n", cause)
+		}
+	})
+}
+
+func (s *session) close() {
+	s.shutdown(errSessionClosed)
+}
+
+// reads }} delimited frames from the socket until it errors or is closed
+// replies to commands are handed to the waiting exchange, pushed state to subscribers
+func (s *session) readLoop() {
+	scanner := bufio.NewScanner(s.conn)
+	scanner.Split(splitFrames)
+	for scanner.Scan() {
+		frame := scanner.Bytes()
+		if s.ih.verbose {
+			fmt.Printf("DEBUG|session| received frame: %s\n", frame)
+		}
+		var r CommandResponse
+		if err := json.Unmarshal(frame, &r); err != nil {
+			if s.ih.verbose {
+				fmt.Printf("DEBUG|session| ignoring malformed frame: %v\n", err)
+			}
+			continue
+		}
+		switch r.Command {
+		case commandPushStatus, commandPushRssi:
+			// unsolicited state changes pushed by the gateway
+			ev, err := decodePush(s.ih.registry, frame)
+			if err != nil {
+				if s.ih.verbose {
+					fmt.Printf("DEBUG|session| ignoring push: %v\n", err)
+				}
+				continue
+			}
+			s.ih.publish(ev)
+			continue
+		}
+		if s.deliver(r) {
+			continue
+		}
+		if r.Command == commandRspSet {
+			// an ack for a set nobody is waiting on says nothing about any other set
+			if s.ih.verbose {
+				fmt.Printf("DEBUG|session| ignoring uncorrelated ack. device: %v seqNo: %v\n", r.Data.DeviceID, r.Data.SeqNo)
+			}
+			continue
+		}
+		if atomic.LoadInt32(&s.waiting) == 0 {
+			// nothing was asked, such as the answer to a keepalive get
+			if s.ih.verbose {
+				fmt.Printf("DEBUG|session| ignoring unsolicited reply: %s\n", r.Command)
+			}
+			continue
+		}
+		select {
+		case s.replies <- r:
+		default:
+			// nobody is waiting on it & an older reply is still pending
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	s.shutdown(newError(ErrUnreachable, "socket read error: %w", err))
+}
+
+// return a frame from a Scanner.Scan which is delimited by }}
+// anything before the opening brace (such as padding nulls) is discarded
+func splitFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.Index(data, []byte{'}', '}'}); i >= 0 {
+		frame := data[0 : i+2]
+		if j := bytes.IndexByte(frame, '{'); j > 0 {
+			frame = frame[j:]
+		}
+		return i + 2, frame, nil
+	}
+	if atEOF {
+		// a trailing partial frame can't be decoded, drop it
+		return len(data), nil, nil
+	}
+	return 0, nil, nil
+}
+
+// hands a reply to the set waiting on its sequence number, if there is one. the real
+// gateway doesn't always echo the sequence number, so an ack without one goes to the
+// oldest set waiting on the same device. an ack for any other sequence number is dropped
+func (s *session) deliver(r CommandResponse) bool {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+	seqNo := r.Data.SeqNo
+	if _, ok := s.pending[seqNo]; !ok {
+		if r.Command != commandRspSet || seqNo != 0 {
+			return false
+		}
+		for n, p := range s.pending {
+			if p.cmd.Data.DeviceID == r.Data.DeviceID && (seqNo == 0 || n < seqNo) {
+				seqNo = n
+			}
+		}
+		if seqNo == 0 {
+			return false
+		}
+	}
+	p := s.pending[seqNo]
+	delete(s.pending, seqNo)
+	p.ack <- r
+	return true
+}
+
+// stops waiting on the sequence number
+func (s *session) forget(seqNo int) {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+	delete(s.pending, seqNo)
+}
+
+// the next sequence number, which increases for the life of the client & is never 0
+func (ih *IntesisHome) nextSeqNo() int {
+	return int(atomic.AddInt64(&ih.seqNo, 1))
+}
+
+// how long to wait on a reply, the socket timeout unless ctx is due sooner in which case bounded is true
+func replyDeadline(ctx context.Context) (deadline time.Time, bounded bool) {
+	deadline = time.Now().Add(_socketReadTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d, true
+	}
+	return
+}
+
+// the error for a reply which didn't turn up in time
+func replyTimeout(cmd *CommandRequest, bounded bool) error {
+	if bounded {
+		return newError(ErrTimeout, "timed out waiting for a reply to %s: %w", cmd.Command, context.DeadlineExceeded)
+	}
+	return newError(ErrTimeout, "timed out waiting for a reply to %s", cmd.Command)
+}
+
+// writes a command to the socket, callers must hold s.mu
+func (s *session) write(cmd *CommandRequest, deadline time.Time) (err error) {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return fmt.Errorf("%s command encode error. cmd: %v cause: %v", cmd.Command, cmd, err)
+	}
+	if s.ih.verbose {
+		fmt.Printf("DEBUG|session| sending request: %s\n", string(b))
+	}
+	s.conn.SetWriteDeadline(deadline)
+	n, err := s.conn.Write(b)
+	if err != nil {
+		err = newError(ErrUnreachable, "socket write error: %w", err)
+		s.shutdown(err)
+		return
+	}
+	if n != len(b) {
+		err = newError(ErrUnreachable, "write byte mismatch, expected: %v actual: %v", len(b), n)
+		s.shutdown(err)
+		return
+	}
+	return
+}
+
+// writes a command & waits for the reply to it, for no longer than the socket
+// timeout or ctx allows. an exchange which is abandoned leaves the session in
+// an unknown state (the reply may still turn up) so the session is shut down
+func (s *session) exchange(ctx context.Context, cmd *CommandRequest) (r CommandResponse, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.alive() {
+		err = s.err
+		return
+	}
+	// discard any reply that turned up after its exchange gave up
+	select {
+	case <-s.replies:
+	default:
+	}
+	atomic.StoreInt32(&s.waiting, 1)
+	defer atomic.StoreInt32(&s.waiting, 0)
+	deadline, bounded := replyDeadline(ctx)
+	if err = s.write(cmd, deadline); err != nil {
+		return
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case r = <-s.replies:
+	case <-s.done:
+		// the gateway may answer & then hang up, so prefer the answer
+		select {
+		case r = <-s.replies:
+		default:
+			err = s.err
+		}
+	case <-timer.C:
+		err = replyTimeout(cmd, bounded)
+		s.shutdown(err)
+	case <-ctx.Done():
+		err = fmt.Errorf("abandoned waiting for a reply to %s: %w", cmd.Command, networkError(ctx.Err()))
+		s.shutdown(err)
+	}
+	return
+}
+
+// authenticates the connection with the token obtained from the control endpoint
+func (s *session) authenticate(ctx context.Context, token int) (err error) {
+	cmd := &CommandRequest{
+		Command: commandReqCon,
+		Data: CommandRequestData{
+			Token: token,
+		},
+	}
+	r, err := s.exchange(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("auth write error. auth: %v cause: %w", cmd, err)
+	}
+	if r.Command != commandRspCon {
+		return newError(ErrProtocol, "unexpected auth reply. expected: %s got: %s", commandRspCon, r.Command)
+	}
+	if r.Data.Status == commandAuthBad {
+		return newError(ErrTokenRejected, "unexpected auth reply. expected: %s got: %s", commandAuthOk, r.Data.Status)
+	}
+	if r.Data.Status != commandAuthOk {
+		return newError(ErrProtocol, "unexpected auth reply. expected: %s got: %s", commandAuthOk, r.Data.Status)
+	}
+	return
+}
+
+// sends a set command for the uid & value & waits for it to be acknowledged
+func (s *session) set(ctx context.Context, device int64, uid, value int) (ack Ack, err error) {
+	p, err := s.send(ctx, device, uid, value)
+	if err != nil {
+		return
+	}
+	return s.await(ctx, p)
+}
+
+// writes a set command under a new sequence number without waiting on the ack
+func (s *session) send(ctx context.Context, device int64, uid, value int) (p pendingSet, err error) {
+	p.cmd = &CommandRequest{
+		Command: commandReqSet,
+		Data: CommandRequestData{
+			DeviceID: device,
+			Uid:      uid,
+			Value:    value,
+			SeqNo:    s.ih.nextSeqNo(),
+		},
+	}
+	p.ack = make(chan CommandResponse, 1)
+	p.sent = time.Now()
+	s.pmu.Lock()
+	s.pending[p.cmd.Data.SeqNo] = p
+	s.pmu.Unlock()
+	s.mu.Lock()
+	if s.alive() {
+		deadline, _ := replyDeadline(ctx)
+		err = s.write(p.cmd, deadline)
+	} else {
+		// only safe to read once done is closed, shutdown doesn't hold mu
+		err = s.err
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.forget(p.cmd.Data.SeqNo)
+		err = fmt.Errorf("set command write error. cmd: %v cause: %w", p.cmd, err)
+		s.ih.observe().ObserveSet(time.Since(p.sent), err)
+	}
+	return
+}
+
+// waits for the ack to a set, which must be for the same device & sequence number
+// an abandoned wait leaves the session in an unknown state so it's shut down
+func (s *session) await(ctx context.Context, p pendingSet) (ack Ack, err error) {
+	defer s.forget(p.cmd.Data.SeqNo)
+	defer func() { s.ih.observe().ObserveSet(time.Since(p.sent), err) }()
+	deadline, bounded := replyDeadline(ctx)
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	var r CommandResponse
+	select {
+	case r = <-p.ack:
+	case <-s.done:
+		// the gateway may answer & then hang up, so prefer the answer
+		select {
+		case r = <-p.ack:
+		default:
+			err = s.err
+		}
+	case <-timer.C:
+		err = replyTimeout(p.cmd, bounded)
+		s.shutdown(err)
+	case <-ctx.Done():
+		err = fmt.Errorf("abandoned waiting for a reply to %s: %w", p.cmd.Command, networkError(ctx.Err()))
+		s.shutdown(err)
+	}
+	if err != nil {
+		err = fmt.Errorf("set command write error. cmd: %v cause: %w", p.cmd, err)
+		return
+	}
+	if r.Command != commandRspSet {
+		err = newError(ErrProtocol, "set command failed. cmd: %v expected: %s got: %s", p.cmd, commandRspSet, r.Command)
+		return
+	}
+	if r.Data.DeviceID != p.cmd.Data.DeviceID {
+		// something was acknowledged under this sequence number, so it mustn't be sent again
+		err = &acknowledgedError{newError(ErrProtocol, "set command ack mismatch. cmd: %v expected device: %v got: %v",
+			p.cmd, p.cmd.Data.DeviceID, r.Data.DeviceID)}
+		return
+	}
+	// the set's own sequence number, as an ack matched by device doesn't carry one
+	ack = Ack{DeviceID: r.Data.DeviceID, SeqNo: p.cmd.Data.SeqNo, Rssi: r.Data.Rssi}
+	if ack.Rssi != 0 {
+		name := s.ih.registry.DecodeUid(_rssiUid)
+		s.ih.publish(StatusEvent{DeviceID: ack.DeviceID, Uid: _rssiUid, Name: name, Value: ack.Rssi, State: s.ih.registry.DecodeState(name, ack.Rssi)})
+	}
+	return
+}
+
+// periodically pokes the gateway so that it doesn't drop an idle connection
+func (s *session) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			cmd := &CommandRequest{
+				Command: commandReqGet,
+				Data: CommandRequestData{
+					DeviceID: s.device,
+					Uid:      _keepaliveUid,
+				},
+			}
+			s.mu.Lock()
+			err := s.write(cmd, time.Now().Add(_socketReadTimeout))
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
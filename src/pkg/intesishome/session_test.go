@@ -0,0 +1,411 @@
+package intesishome
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	_testValidToken   int    = 12345
+	_testAuthRequest  string = `{"command":"connect_req","data":{"deviceId":0,"uid":0,"value":0,"seqNo":0,"token":12345}}`
+	_testAuthResponse string = `{"command":"connect_rsp","data":{"status":"ok"}}`
+	_testAuthFailure  string = `{"command":"connect_rsp","data":{"status":"err_token"}}`
+	_testAuthInvalid  string = `{"command":"garbage","data":{"status":"ok"}}`
+	_testSetResponse  string = `{"command":"set_ack","data":{"deviceId":127934703953,"seqNo":0,"rssi":198}}`
+	_testSetAckTmpl   string = `{"command":"set_ack","data":{"deviceId":%v,"seqNo":%v,"rssi":198}}`
+	_testSetInvalid   string = `{"command":"garbage","data":{"deviceId":%v,"seqNo":%v,"rssi":198}}`
+	_testGetResponse  string = `{"command":"get_ack","data":{"deviceId":127934703953,"uid":10,"value":215}}`
+	_testStatusPush   string = `{"command":"status","data":{"deviceId":127934703953,"uid":10,"value":215}}`
+)
+
+// answers each request frame written by the client with the frames returned by reply
+// returning nil hangs up on the client
+type gatewayReplyFunc func(t *testing.T, request string) []string
+
+// serves a single client connection until it's closed
+func serveGateway(t *testing.T, conn net.Conn, reply gatewayReplyFunc) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(splitFrames)
+	for scanner.Scan() {
+		frames := reply(t, scanner.Text())
+		if frames == nil {
+			return
+		}
+		for _, f := range frames {
+			if _, err := conn.Write([]byte(f)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// a session connected to an in memory gateway
+func testSession(t *testing.T, reply gatewayReplyFunc) *session {
+	client, server := net.Pipe()
+	go serveGateway(t, server, reply)
+	s := newSession(&IntesisHome{registry: DefaultRegistry}, client, 0)
+	t.Cleanup(s.close)
+	return s
+}
+
+// a gateway listening on a real socket, counting the connections it accepts
+func testGatewayListener(t *testing.T, reply gatewayReplyFunc) (addr string, accepts *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	accepts = new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepts, 1)
+			go serveGateway(t, conn, reply)
+		}
+	}()
+	return l.Addr().String(), accepts
+}
+
+// decodes a request frame written by the client
+func decodeRequest(t *testing.T, request string) (cmd CommandRequest) {
+	if err := json.Unmarshal([]byte(request), &cmd); err != nil {
+		t.Errorf("gateway received malformed request: %s", request)
+	}
+	return
+}
+
+// decodes a reply frame written by the gateway
+func decodeResponse(t *testing.T, reply string) (r CommandResponse) {
+	if err := json.Unmarshal([]byte(reply), &r); err != nil {
+		t.Errorf("malformed reply: %s", reply)
+	}
+	return
+}
+
+// whether the request is a set
+func isSet(t *testing.T, request string) bool {
+	return decodeRequest(t, request).Command == commandReqSet
+}
+
+// a reply to the set echoing its device & sequence number
+func ackFor(t *testing.T, request string, tmpl string) string {
+	cmd := decodeRequest(t, request)
+	return fmt.Sprintf(tmpl, cmd.Data.DeviceID, cmd.Data.SeqNo)
+}
+
+// a well behaved gateway which accepts the test token & acknowledges every set
+func okGateway(t *testing.T, request string) []string {
+	cmd := decodeRequest(t, request)
+	switch cmd.Command {
+	case commandReqCon:
+		if cmd.Data.Token != _testValidToken {
+			return []string{_testAuthFailure}
+		}
+		return []string{_testAuthResponse}
+	case commandReqSet:
+		return []string{ackFor(t, request, _testSetAckTmpl)}
+	}
+	return []string{}
+}
+
+// scenarios: bad response payloads, unexpected payloads, EOF, pushed frames, success
+func TestSessionExchange(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	t.Run("valid set response", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			cmd := decodeRequest(t, req)
+			if cmd.Command != commandReqSet || cmd.Data.DeviceID != d || cmd.Data.Uid != 1 || cmd.Data.Value != 0 {
+				t.Errorf("unexpected request: %s", req)
+				return nil
+			}
+			return []string{ackFor(t, req, _testSetAckTmpl)}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		first, err := s.set(context.Background(), d, 1 /* power */, 0 /* off */)
+		assert.NoError(t, err)
+		assert.Equal(t, d, first.DeviceID)
+		assert.Equal(t, 198, first.Rssi)
+		// the session is shared so a further set goes over the same socket
+		second, err := s.set(context.Background(), d, 1 /* power */, 0 /* off */)
+		assert.NoError(t, err)
+		assert.Greater(t, second.SeqNo, first.SeqNo, "sequence numbers increase")
+	})
+	t.Run("acks are correlated", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			// an ack for somebody else's set & then the one for this set
+			return []string{fmt.Sprintf(_testSetAckTmpl, 1, 85), ackFor(t, req, _testSetAckTmpl)}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		ack, err := s.set(context.Background(), d, 1, 0)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 85, ack.SeqNo)
+	})
+	t.Run("acks without the sequence number", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			return []string{_testSetResponse}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		ack, err := s.set(context.Background(), d, 1, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, d, ack.DeviceID)
+		assert.Equal(t, 198, ack.Rssi)
+		assert.NotZero(t, ack.SeqNo, "the set's own sequence number")
+	})
+	t.Run("acks without the sequence number go to the oldest set", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			return []string{}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		first, err := s.send(context.Background(), d, 1, 0)
+		assert.NoError(t, err)
+		second, err := s.send(context.Background(), d, 1, 1)
+		assert.NoError(t, err)
+		assert.True(t, s.deliver(decodeResponse(t, _testSetResponse)))
+		assert.Len(t, first.ack, 1)
+		assert.Len(t, second.ack, 0)
+		// nor does an ack for a device nobody is waiting on go anywhere
+		assert.False(t, s.deliver(decodeResponse(t, fmt.Sprintf(_testSetAckTmpl, 1, 0))))
+		// an ack for a sequence number nobody is waiting on is dropped, not matched by device
+		assert.False(t, s.deliver(decodeResponse(t, fmt.Sprintf(_testSetAckTmpl, d, 85))))
+		assert.Len(t, second.ack, 0)
+	})
+	t.Run("out of order acks for the same device", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			return []string{}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		first, err := s.send(context.Background(), d, 1, 0)
+		assert.NoError(t, err)
+		second, err := s.send(context.Background(), d, 2, 1)
+		assert.NoError(t, err)
+		assert.True(t, s.deliver(decodeResponse(t, fmt.Sprintf(_testSetAckTmpl, d, second.cmd.Data.SeqNo))))
+		assert.True(t, s.deliver(decodeResponse(t, fmt.Sprintf(_testSetAckTmpl, d, first.cmd.Data.SeqNo))))
+		assert.Equal(t, first.cmd.Data.SeqNo, (<-first.ack).Data.SeqNo)
+		assert.Equal(t, second.cmd.Data.SeqNo, (<-second.ack).Data.SeqNo)
+	})
+	t.Run("ack for another device", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			cmd := decodeRequest(t, req)
+			return []string{fmt.Sprintf(_testSetAckTmpl, 1, cmd.Data.SeqNo)}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		_, err := s.set(context.Background(), d, 1, 0)
+		assert.ErrorContains(t, err, "ack mismatch")
+		assert.ErrorIs(t, err, ErrProtocol)
+		assert.False(t, RetryPolicy{Retryable: func(error) bool { return true }}.shouldRetry(err), "acknowledged sets are never retried")
+	})
+	t.Run("invalid auth token", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			assert.Equal(t, _testAuthRequest, req)
+			return []string{_testAuthFailure}
+		})
+		err := s.authenticate(context.Background(), _testValidToken)
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "unexpected auth reply.")
+		assert.ErrorContains(t, err, "expected: ok got: err_token")
+		assert.ErrorIs(t, err, ErrTokenRejected)
+	})
+	t.Run("invalid auth response", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			assert.Equal(t, _testAuthRequest, req)
+			return []string{_testAuthInvalid}
+		})
+		err := s.authenticate(context.Background(), _testValidToken)
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "unexpected auth reply.")
+		assert.ErrorContains(t, err, "expected: connect_rsp got: garbage")
+		assert.ErrorIs(t, err, ErrProtocol)
+	})
+	t.Run("invalid set", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			assert.True(t, isSet(t, req))
+			return []string{ackFor(t, req, _testSetInvalid)}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		_, err := s.set(context.Background(), d, 1 /* power */, 0 /* off */)
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "set command failed.")
+		assert.ErrorContains(t, err, "expected: set_ack got: garbage")
+		assert.ErrorIs(t, err, ErrProtocol)
+	})
+	t.Run("pushed frames are not replies", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testStatusPush, _testAuthResponse}
+			}
+			return []string{_testStatusPush, "\x00\x00" + ackFor(t, req, _testSetAckTmpl)}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		_, err := s.set(context.Background(), d, 1 /* power */, 0 /* off */)
+		assert.NoError(t, err)
+	})
+	t.Run("keepalive replies are not replies", func(t *testing.T) {
+		answered := make(chan struct{}, 16)
+		s := testSession(t, func(t *testing.T, req string) []string {
+			if req == _testAuthRequest {
+				return []string{_testAuthResponse}
+			}
+			if decodeRequest(t, req).Command == commandReqGet {
+				select {
+				case answered <- struct{}{}:
+				default:
+				}
+				return []string{_testGetResponse}
+			}
+			return []string{ackFor(t, req, _testSetAckTmpl)}
+		})
+		assert.NoError(t, s.authenticate(context.Background(), _testValidToken))
+		go s.keepalive(5 * time.Millisecond)
+		<-answered
+		<-answered
+		assert.Never(t, func() bool { return len(s.replies) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+		_, err := s.set(context.Background(), d, 1 /* power */, 0 /* off */)
+		assert.NoError(t, err)
+	})
+	t.Run("read EOF", func(t *testing.T) {
+		s := testSession(t, func(t *testing.T, req string) []string {
+			return nil
+		})
+		err := s.authenticate(context.Background(), _testValidToken)
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "socket read error")
+		assert.ErrorIs(t, err, ErrUnreachable)
+		assert.False(t, s.alive())
+	})
+	t.Run("write error", func(t *testing.T) {
+		client, server := net.Pipe()
+		server.Close()
+		s := newSession(&IntesisHome{registry: DefaultRegistry}, client, 0)
+		defer s.close()
+		err := s.authenticate(context.Background(), _testValidToken)
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "socket")
+	})
+}
+
+func TestSet(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	t.Run("shares the session", func(t *testing.T) {
+		h, err := mockHTTPServer(200, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		addr, accepts := testGatewayListener(t, okGateway)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr))
+		defer ih.Close()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, ih.Set(d, 1, 1))
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(accepts))
+	})
+	t.Run("reconnects once the socket drops", func(t *testing.T) {
+		h, err := mockHTTPServer(200, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		addr, accepts := testGatewayListener(t, func(t *testing.T, req string) []string {
+			if cmd := decodeRequest(t, req); cmd.Command == commandReqSet && cmd.Data.Value == 0 {
+				return nil // hang up without acknowledging
+			}
+			return okGateway(t, req)
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		defer ih.Close()
+		assert.Error(t, ih.Set(d, 1, 0))
+		assert.NoError(t, ih.Set(d, 1, 1))
+		assert.Equal(t, int32(2), atomic.LoadInt32(accepts))
+	})
+	t.Run("retries with a fresh token once the socket drops", func(t *testing.T) {
+		h, err := mockHTTPServer(200, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		var hangups int32
+		addr, accepts := testGatewayListener(t, func(t *testing.T, req string) []string {
+			if isSet(t, req) && atomic.AddInt32(&hangups, 1) == 1 {
+				return nil // hang up without acknowledging, just the once
+			}
+			if !isSet(t, req) && req != _testAuthRequest {
+				t.Errorf("unexpected request: %s", req)
+			}
+			return okGateway(t, req)
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+		defer ih.Close()
+		assert.NoError(t, ih.Set(d, 1, 0))
+		assert.Equal(t, int32(2), atomic.LoadInt32(accepts))
+	})
+	t.Run("honours the context deadline", func(t *testing.T) {
+		h, err := mockHTTPServer(200, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		addr, _ := testGatewayListener(t, func(t *testing.T, req string) []string {
+			if isSet(t, req) {
+				return []string{} // never acknowledge
+			}
+			return okGateway(t, req)
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr))
+		defer ih.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = ih.SetContext(ctx, d, 1, 0)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorIs(t, err, ErrTimeout)
+	})
+	t.Run("gives up on a rejected token", func(t *testing.T) {
+		h, err := mockHTTPServer(200, testValidControlResponsePayload)
+		if err != nil {
+			t.Fatalf("mock http server problem: %v", err.Error())
+		}
+		defer h.Close()
+		addr, accepts := testGatewayListener(t, func(t *testing.T, req string) []string {
+			return []string{_testAuthFailure}
+		})
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+		defer ih.Close()
+		err = ih.Set(d, 1, 1)
+		assert.ErrorContains(t, err, "err_token")
+		assert.ErrorIs(t, err, ErrTokenRejected)
+		assert.Equal(t, int32(3), atomic.LoadInt32(accepts))
+	})
+}
@@ -0,0 +1,97 @@
+package intesishome
+
+import (
+	"context"
+	"strconv"
+)
+
+// an installation (site) within Intesis Home which groups devices
+type Installation struct {
+	ID      int      `json:"id"`
+	Order   int      `json:"order"`
+	Name    string   `json:"name"`
+	Devices []Device `json:"devices,omitempty"`
+}
+
+// a device along with where it's installed & its current state
+type DeviceStatus struct {
+	Device       Device                 `json:"device"`
+	Installation Installation           `json:"installation"` // without its devices
+	Status       map[string]interface{} `json:"status"`       // raw values keyed by their decoded uid
+	State        DeviceState            `json:"state"`        // the decoded values
+	Profile      Profile                `json:"profile"`      // what the device supports
+}
+
+// the state of every configured device, taken from a single control response
+type Snapshot struct {
+	Devices       map[int64]DeviceStatus `json:"devices"`
+	ConfigChanged bool                   `json:"-"` // whether the config differs from the previous poll
+	StatusChanged bool                   `json:"-"` // whether the status differs from the previous poll
+	order         []int64                // device ids in the order they are configured
+}
+
+// the devices in the order they are configured
+func (s *Snapshot) List() (devices []DeviceStatus) {
+	for _, id := range s.order {
+		devices = append(devices, s.Devices[id])
+	}
+	return
+}
+
+// looks up a single device
+func (s *Snapshot) Device(id int64) (d DeviceStatus, ok bool) {
+	d, ok = s.Devices[id]
+	return
+}
+
+// builds the snapshot from a control response via the registry, status for unconfigured devices is dropped
+func newSnapshot(r ControlResponse, reg *Registry) (s Snapshot) {
+	s.Devices = make(map[int64]DeviceStatus)
+	s.ConfigChanged = r.ConfigChanged
+	s.StatusChanged = r.StatusChanged
+	for _, inst := range r.Config.Inst {
+		meta := inst
+		meta.Devices = nil
+		for _, dev := range inst.Devices {
+			id, err := strconv.ParseInt(dev.ID, 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, ok := s.Devices[id]; !ok {
+				s.order = append(s.order, id)
+			}
+			s.Devices[id] = DeviceStatus{
+				Device:       dev,
+				Installation: meta,
+				Status:       make(map[string]interface{}),
+			}
+		}
+	}
+	for _, st := range r.Status.Status {
+		if d, ok := s.Devices[st.DeviceID]; ok {
+			d.Status[reg.DecodeUid(st.UID)] = st.Value
+		}
+	}
+	for id, d := range s.Devices {
+		d.Profile = newProfile(reg, d.Device, d.Status)
+		d.State = newDeviceState(reg, d.Status)
+		d.State.profile = d.Profile
+		s.Devices[id] = d
+	}
+	return
+}
+
+// contacts the Intesis Home API to obtain the state of every device
+func (ih *IntesisHome) StatusAll() (Snapshot, error) {
+	return ih.StatusAllContext(context.Background())
+}
+
+// contacts the Intesis Home API to obtain the state of every device, giving up once ctx is done
+func (ih *IntesisHome) StatusAllContext(ctx context.Context) (s Snapshot, err error) {
+	response, err := ih.control(ctx)
+	if err != nil {
+		return
+	}
+	s = newSnapshot(response, ih.registry)
+	return
+}
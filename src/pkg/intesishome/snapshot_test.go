@@ -0,0 +1,68 @@
+package intesishome
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMultiDeviceResponsePayload string = "./assets/tests/multiDeviceControlResponse.json"
+
+func TestStatusAll(t *testing.T) {
+	s, err := mockHTTPServer(http.StatusOK, testMultiDeviceResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer s.Close()
+	ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
+
+	t.Run("every device from one response", func(t *testing.T) {
+		snapshot, err := ih.StatusAll()
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(snapshot.Devices))
+		_, ok := snapshot.Device(999999999999)
+		assert.False(t, ok, "status for unconfigured devices is dropped")
+
+		bedroom, ok := snapshot.Device(127934703954)
+		assert.True(t, ok)
+		assert.Equal(t, "Bedroom", bedroom.Device.Name)
+		assert.Equal(t, "Home", bedroom.Installation.Name)
+		assert.Nil(t, bedroom.Installation.Devices)
+		assert.Equal(t, map[string]interface{}{"power": 0, "temperature": 190}, bedroom.Status)
+
+		server, _ := snapshot.Device(227934703955)
+		assert.Equal(t, "Office", server.Installation.Name)
+		assert.Equal(t, 80, server.Device.ModelID)
+		assert.Equal(t, map[string]interface{}{"power": 1, "mode": 4}, server.Status)
+
+		names := []string{}
+		for _, d := range snapshot.List() {
+			names = append(names, d.Device.Name)
+		}
+		assert.Equal(t, []string{"Lounge", "Bedroom", "Server Room"}, names)
+	})
+	t.Run("status is a view of the snapshot", func(t *testing.T) {
+		snapshot, _ := ih.StatusAll()
+		for id, d := range snapshot.Devices {
+			state, err := ih.Status(id)
+			assert.NoError(t, err)
+			assert.Equal(t, d.Status, state)
+		}
+	})
+	t.Run("devices is a view of the snapshot", func(t *testing.T) {
+		devices, err := ih.Devices()
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(devices))
+		assert.Equal(t, "227934703955", devices[2].ID)
+	})
+	t.Run("decoded via the client's registry", func(t *testing.T) {
+		r := embeddedRegistry()
+		assert.NoError(t, r.Load([]byte(`{"state": {"10": {"name": "room_temp", "scale": 10}}}`)))
+		snapshot, err := New("u", "p", WithHostname(s.URL), WithRegistry(r)).StatusAll()
+		assert.NoError(t, err)
+		bedroom, _ := snapshot.Device(127934703954)
+		assert.Equal(t, map[string]interface{}{"power": 0, "room_temp": 190}, bedroom.Status)
+		assert.Equal(t, 19.0, bedroom.State.Values()["room_temp"])
+	})
+}
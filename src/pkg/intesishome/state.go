@@ -0,0 +1,228 @@
+package intesishome
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// the power state of a device
+type Power int
+
+const (
+	PowerOff Power = 0
+	PowerOn  Power = 1
+)
+
+// the operating mode of a device
+type Mode int
+
+const (
+	ModeAuto Mode = 0
+	ModeHeat Mode = 1
+	ModeDry  Mode = 2
+	ModeFan  Mode = 3
+	ModeCool Mode = 4
+)
+
+// the fan speed of a device, which speeds exist & what they're called varies by device
+type FanSpeed int
+
+const FanSpeedAuto FanSpeed = 0
+
+// the position of a horizontal or vertical vane
+type VanePosition int
+
+const (
+	VaneAutoStop VanePosition = 0
+	VaneSwing    VanePosition = 10
+)
+
+// named via DefaultRegistry, a DeviceState names its own via DeviceState.Name
+func (p Power) String() string        { return enumName("power", int(p)) }
+func (m Mode) String() string         { return enumName("mode", int(m)) }
+func (f FanSpeed) String() string     { return enumName("fan_speed", int(f)) }
+func (v VanePosition) String() string { return enumName("vvane", int(v)) }
+
+func (p Power) MarshalText() ([]byte, error)        { return []byte(p.String()), nil }
+func (m Mode) MarshalText() ([]byte, error)         { return []byte(m.String()), nil }
+func (f FanSpeed) MarshalText() ([]byte, error)     { return []byte(f.String()), nil }
+func (v VanePosition) MarshalText() ([]byte, error) { return []byte(v.String()), nil }
+
+// the state map name for the value within DefaultRegistry, or the value itself if it has none
+func enumName(name string, value int) string {
+	if v := DecodeState(name, value); v != nil {
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// the state of a device, decoded into engineering units
+// fields are nil when the device doesn't report them
+type DeviceState struct {
+	Power       *Power         `json:"power,omitempty"`
+	Mode        *Mode          `json:"mode,omitempty"`
+	FanSpeed    *FanSpeed      `json:"fan_speed,omitempty"`
+	VVane       *VanePosition  `json:"vvane,omitempty"`
+	HVane       *VanePosition  `json:"hvane,omitempty"`
+	Setpoint    *float64       `json:"setpoint,omitempty"`     // celsius
+	Temperature *float64       `json:"temperature,omitempty"`  // celsius
+	SetpointMin *float64       `json:"setpoint_min,omitempty"` // celsius
+	SetpointMax *float64       `json:"setpoint_max,omitempty"` // celsius
+	Outdoor     *float64       `json:"outdoor_temp,omitempty"` // celsius
+	Rssi        *int           `json:"rssi,omitempty"`
+	ErrorCode   *int           `json:"error_code,omitempty"`
+	Raw         map[string]int `json:"raw"` // every value as reported, keyed by the decoded uid
+	profile     Profile        // what the device calls its modes, fan speeds & vanes
+	registry    *Registry      // the mappings the values are decoded with, DefaultRegistry when nil
+}
+
+// builds the state from raw values keyed by their decoded uid, as returned by Status
+func NewDeviceState(raw map[string]interface{}) (s DeviceState) {
+	return newDeviceState(DefaultRegistry, raw)
+}
+
+// builds the state from raw values, decoded via the registry
+func newDeviceState(reg *Registry, raw map[string]interface{}) (s DeviceState) {
+	s.registry = reg
+	s.Raw = make(map[string]int, len(raw))
+	for name, v := range raw {
+		switch v := v.(type) {
+		case int:
+			s.Set(name, v)
+		case float64:
+			s.Set(name, int(v))
+		}
+	}
+	return
+}
+
+// applies a raw value reported for the named uid, such as one from a StatusEvent
+func (s *DeviceState) Set(name string, value int) {
+	if s.Raw == nil {
+		s.Raw = make(map[string]int)
+	}
+	s.Raw[name] = value
+	switch name {
+	case "power":
+		p := Power(value)
+		s.Power = &p
+	case "mode":
+		m := Mode(value)
+		s.Mode = &m
+	case "fan_speed":
+		f := FanSpeed(value)
+		s.FanSpeed = &f
+	case "vvane":
+		v := VanePosition(value)
+		s.VVane = &v
+	case "hvane":
+		v := VanePosition(value)
+		s.HVane = &v
+	case "setpoint":
+		s.Setpoint = decoded(s.mappings(), name, value)
+	case "temperature":
+		s.Temperature = decoded(s.mappings(), name, value)
+	case "setpoint_min":
+		s.SetpointMin = decoded(s.mappings(), name, value)
+	case "setpoint_max":
+		s.SetpointMax = decoded(s.mappings(), name, value)
+	case "outdoor_temp":
+		s.Outdoor = decoded(s.mappings(), name, value)
+	case "rssi":
+		s.Rssi = &value
+	case "error_code":
+		s.ErrorCode = &value
+	}
+}
+
+// every value decoded via the device's profile or the state map, temperatures in celsius
+func (s DeviceState) Values() map[string]interface{} {
+	values := make(map[string]interface{}, len(s.Raw))
+	for name, v := range s.Raw {
+		if pv, ok := s.profile.Decode(name, v); ok {
+			values[name] = pv
+			continue
+		}
+		values[name] = s.mappings().DecodeState(name, v)
+	}
+	return values
+}
+
+// the name of the value via the device's profile or the state's registry, or the value
+// itself if it has none. unlike String on Power, Mode & the like it honours the client's mappings
+func (s DeviceState) Name(name string, value int) string {
+	if v, ok := s.profile.Decode(name, value); ok {
+		return fmt.Sprint(v)
+	}
+	if v := s.mappings().DecodeState(name, value); v != nil {
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// the enums are named via Name, so by the client's mappings rather than DefaultRegistry
+func (s DeviceState) MarshalJSON() ([]byte, error) {
+	type plain DeviceState
+	return json.Marshal(struct {
+		plain
+		Power    *string `json:"power,omitempty"`
+		Mode     *string `json:"mode,omitempty"`
+		FanSpeed *string `json:"fan_speed,omitempty"`
+		VVane    *string `json:"vvane,omitempty"`
+		HVane    *string `json:"hvane,omitempty"`
+	}{
+		plain:    plain(s),
+		Power:    s.named("power", (*int)(s.Power)),
+		Mode:     s.named("mode", (*int)(s.Mode)),
+		FanSpeed: s.named("fan_speed", (*int)(s.FanSpeed)),
+		VVane:    s.named("vvane", (*int)(s.VVane)),
+		HVane:    s.named("hvane", (*int)(s.HVane)),
+	})
+}
+
+// the name of the value via Name, nil when the device doesn't report it
+func (s DeviceState) named(name string, value *int) *string {
+	if value == nil {
+		return nil
+	}
+	n := s.Name(name, *value)
+	return &n
+}
+
+// the registry the state is decoded with
+func (s DeviceState) mappings() *Registry {
+	if s.registry == nil {
+		return DefaultRegistry
+	}
+	return s.registry
+}
+
+// the value in engineering units via the uid's codec, nil when the device can't report it
+func decoded(reg *Registry, name string, value int) *float64 {
+	v, ok := reg.DecodeState(name, value).(float64)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// contacts the Intesis Home API to obtain the decoded state of a device
+func (ih *IntesisHome) State(device int64) (DeviceState, error) {
+	return ih.StateContext(context.Background(), device)
+}
+
+// contacts the Intesis Home API to obtain the decoded state of a device, giving up once ctx is done
+func (ih *IntesisHome) StateContext(ctx context.Context, device int64) (state DeviceState, err error) {
+	snapshot, err := ih.StatusAllContext(ctx)
+	if err != nil {
+		return
+	}
+	d, ok := snapshot.Device(device)
+	if !ok {
+		err = newError(ErrDeviceNotFound, "device not found: %v", device)
+		return
+	}
+	state = d.State
+	return
+}
@@ -0,0 +1,103 @@
+package intesishome
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceState(t *testing.T) {
+	t.Run("engineering units", func(t *testing.T) {
+		s := NewDeviceState(map[string]interface{}{
+			"power":        1,
+			"mode":         4,
+			"setpoint":     215,
+			"temperature":  198,
+			"vvane":        10,
+			"outdoor_temp": 65526,
+			"setpoint_min": 32768,
+			"unknown":      65535,
+		})
+		assert.Equal(t, PowerOn, *s.Power)
+		assert.Equal(t, ModeCool, *s.Mode)
+		assert.Equal(t, VaneSwing, *s.VVane)
+		assert.Equal(t, 21.5, *s.Setpoint, "fractional degrees are kept")
+		assert.Equal(t, 19.8, *s.Temperature)
+		assert.Equal(t, -1.0, *s.Outdoor, "negative temperatures are two's complement")
+		assert.Nil(t, s.SetpointMin, "unavailable values are nil")
+		assert.Nil(t, s.HVane, "absent features are nil")
+		assert.Nil(t, s.FanSpeed)
+		assert.Equal(t, 65535, s.Raw["unknown"])
+		assert.Equal(t, 215, s.Raw["setpoint"])
+	})
+	t.Run("values", func(t *testing.T) {
+		s := NewDeviceState(map[string]interface{}{"power": 0, "setpoint": 215, "unknown": 7})
+		assert.Equal(t, map[string]interface{}{
+			"power":    "off",
+			"setpoint": 21.5,
+			"unknown":  7,
+		}, s.Values())
+	})
+	t.Run("values via the registry", func(t *testing.T) {
+		r, err := LoadMappings(testMappingOverlay)
+		assert.NoError(t, err)
+		s := newDeviceState(r, map[string]interface{}{"night_mode": 1, "setpoint": 215})
+		assert.Equal(t, map[string]interface{}{"night_mode": "on", "setpoint": 21.5}, s.Values())
+		// signed within the embedded mappings but not the overlay
+		s.Set("setpoint", 65531)
+		assert.Equal(t, 6553.1, *s.Setpoint)
+	})
+	t.Run("set applies pushed values", func(t *testing.T) {
+		var s DeviceState
+		s.Set("mode", 1)
+		s.Set("temperature", -5)
+		assert.Equal(t, ModeHeat, *s.Mode)
+		assert.Equal(t, -0.5, *s.Temperature)
+		assert.Equal(t, "heat", s.Mode.String())
+	})
+	t.Run("enums marshal by name", func(t *testing.T) {
+		b, err := json.Marshal(NewDeviceState(map[string]interface{}{"power": 1, "mode": 9}))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"power":"on","mode":"9","raw":{"power":1,"mode":9}}`, string(b))
+	})
+	t.Run("fan speeds marshal by name", func(t *testing.T) {
+		// only the device's profile names its speeds, so a bare one is its value
+		b, err := json.Marshal(map[string]FanSpeed{"fan_speed": 2})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"fan_speed":"2"}`, string(b))
+	})
+	t.Run("enums named via the registry", func(t *testing.T) {
+		r := embeddedRegistry()
+		assert.NoError(t, r.Load([]byte(`{"state": {"2": {"name": "mode", "values": {"4": "chill"}}}}`)))
+		s := newDeviceState(r, map[string]interface{}{"power": 1, "mode": 4})
+		assert.Equal(t, "chill", s.Name("mode", int(*s.Mode)))
+		assert.Equal(t, "cool", s.Mode.String(), "bare values are named via DefaultRegistry")
+		b, err := json.Marshal(s)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"power":"on","mode":"chill","raw":{"power":1,"mode":4}}`, string(b))
+	})
+	t.Run("enums named via the profile", func(t *testing.T) {
+		s := NewDeviceState(map[string]interface{}{"fan_speed": 1})
+		s.profile = Profile{FanSpeeds: map[int]string{1: "quiet"}}
+		b, err := json.Marshal(s)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"fan_speed":"quiet","raw":{"fan_speed":1}}`, string(b))
+	})
+}
+
+func TestState(t *testing.T) {
+	s, err := mockHTTPServer(http.StatusOK, testMultiDeviceResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer s.Close()
+	ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
+	state, err := ih.State(127934703953)
+	assert.NoError(t, err)
+	assert.Equal(t, PowerOn, *state.Power)
+	assert.Equal(t, 21.5, *state.Temperature)
+	_, err = ih.State(1)
+	assert.ErrorIs(t, err, ErrDeviceNotFound)
+}
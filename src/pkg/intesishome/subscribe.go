@@ -0,0 +1,159 @@
+package intesishome
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	_rssiUid         int = 60002 // the state map name for rssi pushes
+	_subscribeBuffer int = 64
+)
+
+// a change of state pushed by the TCP gateway
+type StatusEvent struct {
+	DeviceID int64       `json:"deviceId"`
+	Uid      int         `json:"uid"`
+	Name     string      `json:"name"`  // the decoded uid
+	Value    int         `json:"value"` // the raw value
+	State    interface{} `json:"state"` // the decoded value
+}
+
+// status & rssi frames as pushed by the gateway
+type pushFrame struct {
+	Command string `json:"command"`
+	Data    struct {
+		DeviceID int64 `json:"deviceId"`
+		Uid      int   `json:"uid"`
+		Value    int   `json:"value"`
+	} `json:"data"`
+}
+
+// decodes a pushed frame into an event, rssi frames carry no uid so they're given the rssi one
+func decodePush(reg *Registry, frame []byte) (ev StatusEvent, err error) {
+	var p pushFrame
+	if err = json.Unmarshal(frame, &p); err != nil {
+		err = newError(ErrProtocol, "push frame decode error. frame: %s cause: %v", string(frame), err)
+		return
+	}
+	ev.DeviceID = p.Data.DeviceID
+	ev.Uid = p.Data.Uid
+	if p.Command == commandPushRssi {
+		ev.Uid = _rssiUid
+	}
+	ev.Value = p.Data.Value
+	ev.Name = reg.DecodeUid(ev.Uid)
+	ev.State = reg.DecodeState(ev.Name, ev.Value)
+	return
+}
+
+// streams the state changes pushed by the TCP gateway, or a backend which pushes, for every device
+// on the account. other backends fail with errNoPush. the subscription survives the session dropping
+// & is ended, closing the channel, when ctx is done or the client is closed. events are dropped if
+// the reader falls behind
+func (ih *IntesisHome) Subscribe(ctx context.Context) (<-chan StatusEvent, error) {
+	if ih.err != nil {
+		return nil, ih.err
+	}
+	err := ih.retry.do(ctx, ih.verbose, func() error {
+		_, err := ih.pushes(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan StatusEvent, _subscribeBuffer)
+	ih.subMu.Lock()
+	if ih.subs == nil {
+		ih.subs = make(map[chan StatusEvent]context.CancelFunc)
+	}
+	ih.subs[ch] = cancel
+	ih.subMu.Unlock()
+	go ih.maintainSubscription(ctx, ch)
+	return ch, nil
+}
+
+// keeps a session open for as long as the subscription is wanted
+func (ih *IntesisHome) maintainSubscription(ctx context.Context, ch chan StatusEvent) {
+	defer ih.unsubscribe(ch)
+	// keeps on trying for as long as the subscription is wanted, backing off as per the RetryPolicy
+	failures := 0
+	for ctx.Err() == nil {
+		done, err := ih.pushes(ctx)
+		if err != nil {
+			failures++
+			backoff := ih.retry.backoff(failures)
+			if ih.verbose {
+				fmt.Printf("DEBUG|subscribe| unable to re-establish session, retrying in %v: %v\n", backoff, err)
+			}
+			if !sleepCtx(ctx, backoff) {
+				return
+			}
+			continue
+		}
+		failures = 0
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+		}
+		// don't hammer the cloud if sessions are dying as soon as they're up
+		if !sleepCtx(ctx, ih.retry.backoff(1)) {
+			return
+		}
+	}
+}
+
+// removes & closes a subscription
+func (ih *IntesisHome) unsubscribe(ch chan StatusEvent) {
+	ih.subMu.Lock()
+	defer ih.subMu.Unlock()
+	if cancel, ok := ih.subs[ch]; ok {
+		cancel()
+		delete(ih.subs, ch)
+		close(ch)
+	}
+}
+
+// ends every subscription
+func (ih *IntesisHome) unsubscribeAll() {
+	ih.subMu.Lock()
+	subs := make([]chan StatusEvent, 0, len(ih.subs))
+	for ch := range ih.subs {
+		subs = append(subs, ch)
+	}
+	ih.subMu.Unlock()
+	for _, ch := range subs {
+		ih.unsubscribe(ch)
+	}
+}
+
+// hands an event to every subscriber without blocking the session reader
+func (ih *IntesisHome) publish(ev StatusEvent) {
+	ih.subMu.Lock()
+	defer ih.subMu.Unlock()
+	for ch := range ih.subs {
+		select {
+		case ch <- ev:
+		default:
+			if ih.verbose {
+				fmt.Printf("DEBUG|subscribe| subscriber is full, dropping: %+v\n", ev)
+			}
+		}
+	}
+}
+
+// sleeps for d, returning false if ctx was done first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
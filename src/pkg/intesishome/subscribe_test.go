@@ -0,0 +1,144 @@
+package intesishome
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	_testRssiPush       string = `{"command":"rssi","data":{"deviceId":127934703953,"value":180}}`
+	_testStatusPushTmpl string = `{"command":"status","data":{"deviceId":127934703953,"uid":%v,"value":%v}}`
+)
+
+func TestDecodePush(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	tests := []struct {
+		name  string
+		frame string
+		want  StatusEvent
+	}{
+		{
+			"status push",
+			`{"command":"status","data":{"deviceId":127934703953,"uid":2,"value":4}}`,
+			StatusEvent{DeviceID: d, Uid: 2, Name: "mode", Value: 4, State: "cool"},
+		},
+		{
+			"scaled status push",
+			_testStatusPush,
+			StatusEvent{DeviceID: d, Uid: 10, Name: "temperature", Value: 215, State: 21.5},
+		},
+		{
+			"rssi push",
+			_testRssiPush,
+			StatusEvent{DeviceID: d, Uid: _rssiUid, Name: "rssi", Value: 180, State: 180},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := decodePush(DefaultRegistry, []byte(tt.frame))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, ev)
+		})
+	}
+	t.Run("via the registry", func(t *testing.T) {
+		r, err := LoadMappings(testMappingOverlay)
+		assert.NoError(t, err)
+		ev, err := decodePush(r, []byte(`{"command":"status","data":{"deviceId":127934703953,"uid":200,"value":1}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, StatusEvent{DeviceID: d, Uid: 200, Name: "night_mode", Value: 1, State: "on"}, ev)
+	})
+	t.Run("malformed push", func(t *testing.T) {
+		_, err := decodePush(DefaultRegistry, []byte(`{"command":"status","data":{"uid":"x"}}`))
+		assert.ErrorContains(t, err, "push frame decode error")
+	})
+}
+
+// a gateway which pushes the new power state after acknowledging a set
+func pushingGateway(t *testing.T, request string) []string {
+	var cmd CommandRequest
+	if err := json.Unmarshal([]byte(request), &cmd); err != nil {
+		return nil
+	}
+	if cmd.Command == commandReqSet {
+		push := fmt.Sprintf(_testStatusPushTmpl, cmd.Data.Uid, cmd.Data.Value)
+		return []string{ackFor(t, request, _testSetAckTmpl), push, _testRssiPush}
+	}
+	return okGateway(t, request)
+}
+
+func TestSubscribe(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	h, err := mockHTTPServer(200, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer h.Close()
+	t.Run("receives pushed state", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, pushingGateway)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr))
+		defer ih.Close()
+		events, err := ih.Subscribe(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, ih.Set(d, 1, 1))
+		want := []StatusEvent{
+			{DeviceID: d, Uid: 1, Name: "power", Value: 1, State: "on"},
+			{DeviceID: d, Uid: _rssiUid, Name: "rssi", Value: 180, State: 180},
+			// the rssi carried by the ack
+			{DeviceID: d, Uid: _rssiUid, Name: "rssi", Value: 198, State: 198},
+		}
+		got := []StatusEvent{}
+		for range want {
+			select {
+			case ev := <-events:
+				got = append(got, ev)
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for: %+v got: %+v", want, got)
+			}
+		}
+		assert.ElementsMatch(t, want, got)
+	})
+	t.Run("cancelling closes the channel", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, pushingGateway)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr))
+		defer ih.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := ih.Subscribe(ctx)
+		assert.NoError(t, err)
+		cancel()
+		select {
+		case _, ok := <-events:
+			assert.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("channel was not closed")
+		}
+	})
+	t.Run("resubscribes once the socket drops", func(t *testing.T) {
+		addr, accepts := testGatewayListener(t, pushingGateway)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+		defer ih.Close()
+		events, err := ih.Subscribe(context.Background())
+		assert.NoError(t, err)
+		s, _ := ih.session(context.Background())
+		s.close()
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(accepts) == 2 }, time.Second, 5*time.Millisecond)
+		assert.NoError(t, ih.Set(d, 1, 0))
+		select {
+		case ev := <-events:
+			assert.Equal(t, "off", ev.State)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the push")
+		}
+	})
+	t.Run("unreachable gateway", func(t *testing.T) {
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer("127.0.0.1:1"), WithRetryPolicy(RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Millisecond}))
+		_, err := ih.Subscribe(context.Background())
+		assert.Error(t, err)
+	})
+}
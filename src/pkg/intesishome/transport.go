@@ -0,0 +1,179 @@
+package intesishome
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// dials the TCP gateway, satisfied by net.Dialer, tls.Dialer & the golang.org/x/net/proxy dialers
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// the HTTP client used to call the Intesis Cloud, for timeouts, CA bundles, pooling & the like
+func WithHTTPClient(c *http.Client) Option {
+	return func(ih *IntesisHome) {
+		ih.client = c
+	}
+}
+
+// the transport used to call the Intesis Cloud, such as a proxy or a recording RoundTripper
+// applied to a copy of the HTTP client so it doesn't leak into other users of that client,
+// whether WithHTTPClient comes before or after it
+func WithTransport(rt http.RoundTripper) Option {
+	return func(ih *IntesisHome) {
+		ih.transport = rt
+	}
+}
+
+// how the TCP gateway is dialed
+func WithDialer(d Dialer) Option {
+	return func(ih *IntesisHome) {
+		ih.dialer = d
+	}
+}
+
+// dials the TCP gateway via a proxy. http & https proxies are tunnelled through with CONNECT,
+// socks5 via golang.org/x/net/proxy. credentials are taken from the URL. nil dials directly
+func WithTCPProxy(u *url.URL) Option {
+	return func(ih *IntesisHome) {
+		if u == nil {
+			return
+		}
+		ih.dialer = proxyDialer(u, ih.dialer)
+	}
+}
+
+// a dialer which goes through the proxy at u, reaching the proxy itself via forward
+func proxyDialer(u *url.URL, forward Dialer) Dialer {
+	switch u.Scheme {
+	case "http":
+		return &connectDialer{proxy: u, forward: forward}
+	case "https":
+		return &connectDialer{proxy: u, forward: tlsDialer{forward: forward, serverName: u.Hostname()}}
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(u, proxyForward{forward})
+		if err != nil {
+			return failedDialer{err}
+		}
+		if cd, ok := d.(proxy.ContextDialer); ok {
+			return cd
+		}
+	}
+	return failedDialer{fmt.Errorf("unsupported TCP proxy scheme: %s", u.Scheme)}
+}
+
+// a proxy which can't be used, failing every dial
+type failedDialer struct {
+	err error
+}
+
+func (d failedDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, d.err
+}
+
+// speaks TLS over whatever forward dials
+type tlsDialer struct {
+	forward    Dialer
+	serverName string
+}
+
+func (d tlsDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(conn, &tls.Config{ServerName: d.serverName})
+	if err = tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// adapts a Dialer for golang.org/x/net/proxy which also wants a plain Dial
+type proxyForward struct {
+	Dialer
+}
+
+func (d proxyForward) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// tunnels through an HTTP proxy with CONNECT
+type connectDialer struct {
+	proxy   *url.URL
+	forward Dialer
+}
+
+func (d *connectDialer) DialContext(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+	proxyAddr := d.proxy.Host
+	if d.proxy.Port() == "" {
+		port := "80"
+		if d.proxy.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.proxy.Hostname(), port)
+	}
+	if conn, err = d.forward.DialContext(ctx, network, proxyAddr); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxy.User; user != nil {
+		pass, _ := user.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err = req.Write(conn); err != nil {
+		return
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("proxy: %s refused to CONNECT to: %s status: %s", d.proxy.Redacted(), addr, resp.Status)
+		return
+	}
+	if br.Buffered() > 0 {
+		// the gateway has already said something, keep hold of it
+		conn = &bufferedConn{Conn: conn, r: br}
+	}
+	return
+}
+
+// a connection with data already read off it
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,130 @@
+package intesishome
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counts the requests passing through it
+type countingTransport struct {
+	requests int32
+}
+
+func (rt *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&rt.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// a CONNECT proxy which tunnels to wherever it's asked, or refuses with status
+func testConnectProxy(t *testing.T, status int) (addr string, connects *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	connects = new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				atomic.AddInt32(connects, 1)
+				if status != http.StatusOK {
+					io.WriteString(conn, "HTTP/1.1 "+strconv.Itoa(status)+" "+http.StatusText(status)+"\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l.Addr().String(), connects
+}
+
+func TestTransport(t *testing.T) {
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	h, err := mockHTTPServer(200, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer h.Close()
+
+	t.Run("with transport", func(t *testing.T) {
+		rt := &countingTransport{}
+		ih := New("u", "p", WithHostname(h.URL), WithTransport(rt))
+		_, err := ih.StatusAll()
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&rt.requests))
+		assert.Nil(t, http.DefaultClient.Transport, "the default client is left alone")
+	})
+	t.Run("with transport & http client in either order", func(t *testing.T) {
+		for _, opts := range [][]Option{
+			{WithTransport(&countingTransport{}), WithHTTPClient(&http.Client{Timeout: time.Minute})},
+			{WithHTTPClient(&http.Client{Timeout: time.Minute}), WithTransport(&countingTransport{})},
+		} {
+			ih := New("u", "p", append([]Option{WithHostname(h.URL)}, opts...)...)
+			_, err := ih.StatusAll()
+			assert.NoError(t, err)
+			rt, ok := ih.client.Transport.(*countingTransport)
+			if assert.True(t, ok) {
+				assert.Equal(t, int32(1), atomic.LoadInt32(&rt.requests))
+			}
+			assert.Equal(t, time.Minute, ih.client.Timeout, "the given client is kept")
+		}
+	})
+	t.Run("with http client", func(t *testing.T) {
+		rt := &countingTransport{}
+		ih := New("u", "p", WithHostname(h.URL), WithHTTPClient(&http.Client{Transport: rt}))
+		_, err := ih.StatusAll()
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&rt.requests))
+	})
+	t.Run("through a CONNECT proxy", func(t *testing.T) {
+		addr, _ := testGatewayListener(t, okGateway)
+		proxyAddr, connects := testConnectProxy(t, http.StatusOK)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0),
+			WithTCPProxy(&url.URL{Scheme: "http", Host: proxyAddr}))
+		defer ih.Close()
+		assert.NoError(t, ih.Set(d, 1, 1))
+		assert.Equal(t, int32(1), atomic.LoadInt32(connects))
+	})
+	t.Run("proxy refuses", func(t *testing.T) {
+		addr, accepts := testGatewayListener(t, okGateway)
+		proxyAddr, _ := testConnectProxy(t, http.StatusProxyAuthRequired)
+		ih := New("u", "p", WithHostname(h.URL), WithTCPServer(addr), WithSetDelay(0), WithRetryPolicy(_testRetryPolicy),
+			WithTCPProxy(&url.URL{Scheme: "http", Host: proxyAddr}))
+		defer ih.Close()
+		err := ih.Set(d, 1, 1)
+		assert.ErrorIs(t, err, ErrUnreachable)
+		assert.ErrorContains(t, err, "407")
+		assert.Equal(t, int32(0), atomic.LoadInt32(accepts))
+	})
+	t.Run("unsupported proxy", func(t *testing.T) {
+		ih := New("u", "p", WithHostname(h.URL), WithSetDelay(0), WithRetryPolicy(_testRetryPolicy),
+			WithTCPProxy(&url.URL{Scheme: "ftp", Host: "localhost"}))
+		defer ih.Close()
+		assert.ErrorContains(t, ih.Set(d, 1, 1), "unsupported TCP proxy scheme: ftp")
+	})
+}
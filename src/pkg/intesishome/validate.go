@@ -0,0 +1,101 @@
+package intesishome
+
+import "context"
+
+const _setpointUid int = 9
+
+// checks a mapped command against what the device supports & its current limits
+// a uid is unsupported when the device neither reports it nor lists it amongst its widgets
+// unless it's written by a write only command, such as resync, which nothing reports back
+func (d DeviceStatus) ValidateCommand(uid, value int) error {
+	reg := d.State.mappings()
+	name := reg.DecodeUid(uid)
+	if len(d.Status) > 0 && !reg.WriteOnly(uid) && !d.supports(uid) {
+		return newError(ErrInvalidCommand, "command: %v is not supported by device: %v (%v)", name, d.Device.ID, d.Device.Name)
+	}
+	if !d.Profile.Supports(name, value) {
+		return newError(ErrInvalidCommand, "value: %v for command: %v is not supported by device: %v (%v) wanted: %v",
+			value, name, d.Device.ID, d.Device.Name, d.Profile.Names(name))
+	}
+	if uid != _setpointUid {
+		return nil
+	}
+	v, ok := reg.Codec(uid).Decode(value)
+	if !ok {
+		return nil
+	}
+	if min := d.State.SetpointMin; min != nil && v < *min {
+		return newError(ErrInvalidCommand, "value: %v for command: %v is below the device minimum: %v", v, name, *min)
+	}
+	if max := d.State.SetpointMax; max != nil && v > *max {
+		return newError(ErrInvalidCommand, "value: %v for command: %v is above the device maximum: %v", v, name, *max)
+	}
+	return nil
+}
+
+// whether the device reports the uid or lists it amongst its widgets
+func (d DeviceStatus) supports(uid int) bool {
+	if _, ok := d.Status[d.State.mappings().DecodeUid(uid)]; ok {
+		return true
+	}
+	for _, w := range d.Device.Widgets {
+		if w == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// maps the command via the device's registry & validates it against the device
+// names from the device's profile are accepted, such as fan_speed quiet
+func (d DeviceStatus) MapCommand(key string, value interface{}) (uid, mValue int, err error) {
+	if s, ok := value.(string); ok {
+		if raw, ok := d.Profile.Encode(key, s); ok {
+			value = raw
+		}
+	}
+	if uid, mValue, err = d.State.mappings().MapCommand(key, value); err != nil {
+		return
+	}
+	err = d.ValidateCommand(uid, mValue)
+	return
+}
+
+// contacts the Intesis Home API to map a command & validate it against the device's current state
+func (ih *IntesisHome) MapCommand(device int64, key string, value interface{}) (uid, mValue int, err error) {
+	return ih.MapCommandContext(context.Background(), device, key, value)
+}
+
+// contacts the Intesis Home API to map a command & validate it against the device's current state,
+// giving up once ctx is done
+func (ih *IntesisHome) MapCommandContext(ctx context.Context, device int64, key string, value interface{}) (uid, mValue int, err error) {
+	snapshot, err := ih.StatusAllContext(ctx)
+	if err != nil {
+		return
+	}
+	d, ok := snapshot.Device(device)
+	if !ok {
+		err = newError(ErrDeviceNotFound, "device not found: %v", device)
+		return
+	}
+	return d.MapCommand(key, value)
+}
+
+// contacts the Intesis Home API to validate a mapped command against the device's current state
+func (ih *IntesisHome) ValidateCommand(device int64, uid, value int) error {
+	return ih.ValidateCommandContext(context.Background(), device, uid, value)
+}
+
+// contacts the Intesis Home API to validate a mapped command against the device's current state,
+// giving up once ctx is done
+func (ih *IntesisHome) ValidateCommandContext(ctx context.Context, device int64, uid, value int) error {
+	snapshot, err := ih.StatusAllContext(ctx)
+	if err != nil {
+		return err
+	}
+	d, ok := snapshot.Device(device)
+	if !ok {
+		return newError(ErrDeviceNotFound, "device not found: %v", device)
+	}
+	return d.ValidateCommand(uid, value)
+}
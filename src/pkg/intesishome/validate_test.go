@@ -0,0 +1,76 @@
+package intesishome
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCommand(t *testing.T) {
+	d := DeviceStatus{
+		Device: Device{ID: "1", Name: "Lounge", Widgets: []int{63}},
+		Status: map[string]interface{}{"power": 1, "setpoint": 210, "setpoint_min": 180, "setpoint_max": 300},
+	}
+	d.State = NewDeviceState(d.Status)
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		err   string
+	}{
+		{"reported uid", "power", "off", ""},
+		{"within the device limits", "setpoint", 21.5, ""},
+		{"below the device minimum", "setpoint", 17.5, "below the device minimum: 18"},
+		{"above the device maximum", "setpoint", "30.5", "above the device maximum: 30"},
+		{"unsupported uid", "vvane", "swing", "not supported by device: 1 (Lounge)"},
+		{"write only uid", "resync", "on", ""},
+		{"write only error reset", "reset_eror", "on", ""},
+		{"static range is checked 1st", "thermoshift_heat_eco", 9, "above the maximum: 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := d.MapCommand(tt.key, tt.value)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+			assert.ErrorIs(t, err, ErrInvalidCommand)
+		})
+	}
+	t.Run("listed widgets are supported", func(t *testing.T) {
+		assert.NoError(t, d.ValidateCommand(63, 1))
+	})
+	t.Run("commands via the device's registry", func(t *testing.T) {
+		r, err := LoadMappings(testMappingOverlay)
+		assert.NoError(t, err)
+		d := DeviceStatus{Status: map[string]interface{}{"night_mode": 0}}
+		d.State = newDeviceState(r, d.Status)
+		uid, v, err := d.MapCommand("night_mode", "on")
+		assert.NoError(t, err)
+		assert.Equal(t, 200, uid)
+		assert.Equal(t, 1, v)
+		_, _, err = DeviceStatus{State: NewDeviceState(nil)}.MapCommand("night_mode", "on")
+		assert.ErrorIs(t, err, ErrInvalidCommand)
+	})
+	t.Run("unavailable limits are ignored", func(t *testing.T) {
+		d := DeviceStatus{Status: map[string]interface{}{"setpoint": 210, "setpoint_min": 32768}}
+		d.State = NewDeviceState(d.Status)
+		assert.NoError(t, d.ValidateCommand(_setpointUid, 50))
+	})
+}
+
+func TestClientValidateCommand(t *testing.T) {
+	s, err := mockHTTPServer(http.StatusOK, testValidControlResponsePayload)
+	if err != nil {
+		t.Fatalf("mock http server problem: %v", err.Error())
+	}
+	defer s.Close()
+	ih := New("u", "p", WithHostname(s.URL), WithRetryPolicy(_testRetryPolicy))
+	d, _ := strconv.ParseInt(testDeviceId, 10, 64)
+	assert.NoError(t, ih.ValidateCommand(d, _setpointUid, 220))
+	assert.ErrorIs(t, ih.ValidateCommand(d, _setpointUid, 310), ErrInvalidCommand)
+	assert.ErrorIs(t, ih.ValidateCommand(1, _setpointUid, 220), ErrDeviceNotFound)
+}
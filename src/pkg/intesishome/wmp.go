@@ -0,0 +1,428 @@
+package intesishome
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWMPPort int = 3310
+	_wmpAC         int = 1 // the unit addressed on the gateway, IntesisBox WMP gateways have the one
+)
+
+// a WMP function & how its values map onto the uid & raw values the Intesis Cloud uses
+type wmpFunction struct {
+	uid    int
+	values map[string]int // named values, anything else is numeric
+}
+
+// the WMP functions the client understands, temperatures are tenths of a degree as with the cloud
+var _wmpFunctions = map[string]wmpFunction{
+	"ONOFF":    {uid: 1, values: map[string]int{"OFF": 0, "ON": 1}},
+	"MODE":     {uid: 2, values: map[string]int{"AUTO": 0, "HEAT": 1, "DRY": 2, "FAN": 3, "COOL": 4}},
+	"FANSP":    {uid: 4, values: map[string]int{"AUTO": 0}},
+	"VANEUD":   {uid: 5, values: map[string]int{"AUTO": 0, "SWING": 10}},
+	"VANELR":   {uid: 6, values: map[string]int{"AUTO": 0, "SWING": 10}},
+	"SETPTEMP": {uid: 9},
+	"AMBTEMP":  {uid: 10},
+	"ERRCODE":  {uid: 15},
+}
+
+// the functions whose LIMITS become the config uids & setpoint range the profile is built from
+var _wmpLimits = []string{"MODE", "VANEUD", "VANELR", "SETPTEMP"}
+
+// the raw value of a WMP value, negative temperatures become two's complement as the cloud sends them
+func (f wmpFunction) decode(reg *Registry, value string) (raw int, ok bool) {
+	if raw, ok = f.values[value]; ok {
+		return
+	}
+	raw, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	if raw < 0 && reg.Codec(f.uid).Signed {
+		raw += 1 << 16
+	}
+	return raw, true
+}
+
+// the WMP value for a raw value
+func (f wmpFunction) encode(reg *Registry, raw int) string {
+	for name, v := range f.values {
+		if v == raw {
+			return name
+		}
+	}
+	if reg.Codec(f.uid).Signed && raw >= 1<<15 && raw < 1<<16 {
+		raw -= 1 << 16
+	}
+	return strconv.Itoa(raw)
+}
+
+// the function for a uid
+func wmpFunctionFor(uid int) (name string, f wmpFunction, ok bool) {
+	for name, f = range _wmpFunctions {
+		if f.uid == uid {
+			return name, f, true
+		}
+	}
+	return
+}
+
+// what the gateway says about itself in reply to ID
+// ID:IS-IR-WMP-1,001DC9A183E1,192.168.100.246,ASCII,v1.0.1,-51,TEST,N,1
+type wmpInfo struct {
+	Model    string
+	MAC      string
+	IP       string
+	Protocol string
+	Version  string
+	Rssi     int
+	Name     string
+}
+
+func parsewmpInfo(line string) (i wmpInfo, err error) {
+	fields := strings.Split(strings.TrimPrefix(line, "ID:"), ",")
+	if !strings.HasPrefix(line, "ID:") || len(fields) < 5 {
+		err = newError(ErrProtocol, "malformed ID reply: %s", line)
+		return
+	}
+	i.Model, i.MAC, i.IP, i.Protocol, i.Version = fields[0], fields[1], fields[2], fields[3], fields[4]
+	if len(fields) > 5 {
+		i.Rssi, _ = strconv.Atoi(fields[5])
+	}
+	if len(fields) > 6 {
+		i.Name = fields[6]
+	}
+	return
+}
+
+// the device id, taken from the MAC address
+func (i wmpInfo) id() int64 {
+	id, err := strconv.ParseInt(strings.ReplaceAll(i.MAC, ":", ""), 16, 64)
+	if err != nil {
+		return 1
+	}
+	return id
+}
+
+// an IntesisBox gateway speaking the plain text WMP protocol, one line per frame
+// CHN frames carry the value of a function & are sent both in reply to GET & whenever
+// the unit changes, so they're applied as they arrive & everything else is a reply
+type wmp struct {
+	ih      *IntesisHome // for the dialer & verbosity
+	addr    string
+	conn    net.Conn
+	replies chan string
+	done    chan struct{} // closed once the connection is unusable
+	info    wmpInfo
+	limits  map[string][]string // function to the values it allows
+	values  map[string]string   // function to its latest value
+	vmu     sync.Mutex          // guards info & values, which the reader updates
+	mu      sync.Mutex          // one exchange at a time
+}
+
+// talks to the IntesisBox gateway at host[:port] over WMP rather than the Intesis Cloud
+// the gateway pushes changes so Subscribe works as it does with the TCP gateway
+func WithWMP(host string) Option {
+	return func(ih *IntesisHome) {
+		if host == "" {
+			return
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(DefaultWMPPort))
+		}
+		ih.backend = &wmp{ih: ih, addr: host}
+	}
+}
+
+// the device & its latest values, shaped as a control response. GET,1:* is fenced with
+// an ID, since the gateway answers in order every CHN has been applied once the ID is back
+func (w *wmp) control(ctx context.Context) (r ControlResponse, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err = w.connect(ctx); err != nil {
+		return
+	}
+	deadline, _ := replyDeadline(ctx)
+	if err = w.write(fmt.Sprintf("GET,%v:*", _wmpAC), deadline); err != nil {
+		return
+	}
+	if _, err = w.exchange(ctx, "ID", isID); err != nil {
+		return
+	}
+	w.vmu.Lock()
+	defer w.vmu.Unlock()
+	id := w.info.id()
+	name := w.info.Name
+	if name == "" {
+		name = w.info.Model
+	}
+	r.Config.Inst = []Installation{{
+		Name:    w.addr,
+		Devices: []Device{{ID: fmt.Sprint(id), Name: name}},
+	}}
+	add := func(uid, value int) {
+		r.Status.Status = append(r.Status.Status, StatusValue{DeviceID: id, UID: uid, Value: value})
+	}
+	for fn, v := range w.values {
+		if f, ok := _wmpFunctions[fn]; ok {
+			if raw, ok := f.decode(w.ih.registry, v); ok {
+				add(f.uid, raw)
+			}
+		}
+	}
+	add(_rssiUid, w.info.Rssi)
+	for fn, allowed := range w.limits {
+		if uid, value, ok := wmpLimit(w.ih.registry, fn, allowed); ok {
+			add(uid, value)
+		}
+	}
+	if l := w.limits["SETPTEMP"]; len(l) == 2 {
+		f := _wmpFunctions["SETPTEMP"]
+		for i, name := range []string{"setpoint_min", "setpoint_max"} {
+			e, ok := w.ih.registry.Name(name)
+			if raw, valid := f.decode(w.ih.registry, l[i]); ok && valid {
+				add(e.Uid, raw)
+			}
+		}
+	}
+	r.ConfigChanged, r.StatusChanged = true, true
+	return
+}
+
+// the config uid & its value for the LIMITS of a function, each allowed raw value is a bit
+func wmpLimit(reg *Registry, fn string, allowed []string) (uid, value int, ok bool) {
+	var config string
+	switch fn {
+	case "MODE":
+		config = "config_mode_map"
+	case "VANEUD":
+		config = "config_vertical_vanes"
+	case "VANELR":
+		config = "config_horizontal_vanes"
+	default:
+		return
+	}
+	e, ok := reg.Name(config)
+	if !ok {
+		return
+	}
+	f := _wmpFunctions[fn]
+	for _, v := range allowed {
+		if raw, ok := f.decode(reg, v); ok {
+			value |= 1 << raw
+		}
+	}
+	return e.Uid, value, true
+}
+
+// sets the function for the uid, the gateway answers ACK or ERR & the change follows as a CHN
+func (w *wmp) set(ctx context.Context, device int64, uid, value int) (ack Ack, err error) {
+	name, f, ok := wmpFunctionFor(uid)
+	if !ok {
+		err = newError(ErrInvalidCommand, "uid: %v (%v) has no WMP function", uid, w.ih.registry.DecodeUid(uid))
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err = w.connect(ctx); err != nil {
+		return
+	}
+	w.vmu.Lock()
+	id := w.info.id()
+	w.vmu.Unlock()
+	if id != device {
+		err = newError(ErrDeviceNotFound, "device not found: %v", device)
+		return
+	}
+	line := fmt.Sprintf("SET,%v:%s,%s", _wmpAC, name, f.encode(w.ih.registry, value))
+	reply, err := w.exchange(ctx, line, isAck)
+	if err != nil {
+		return
+	}
+	if reply != "ACK" {
+		err = newError(ErrInvalidCommand, "gateway refused: %s got: %s", line, reply)
+		return
+	}
+	ack.DeviceID = device
+	return
+}
+
+// keeps the connection up for as long as the subscription wants pushes
+func (w *wmp) connected(ctx context.Context) (<-chan struct{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.connect(ctx); err != nil {
+		return nil, err
+	}
+	return w.done, nil
+}
+
+func (w *wmp) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+// dials the gateway if need be, asking who it is & what its functions allow. callers must hold w.mu
+func (w *wmp) connect(ctx context.Context) (err error) {
+	if w.conn != nil {
+		select {
+		case <-w.done:
+		default:
+			return nil
+		}
+	}
+	conn, err := w.ih.dialer.DialContext(ctx, "tcp", w.addr)
+	if err != nil {
+		return networkError(err)
+	}
+	w.conn = conn
+	w.replies = make(chan string, 1)
+	w.done = make(chan struct{})
+	w.vmu.Lock()
+	w.values = make(map[string]string)
+	w.vmu.Unlock()
+	go w.readLoop(conn, w.replies, w.done)
+
+	reply, err := w.exchange(ctx, "ID", isID)
+	if err != nil {
+		return
+	}
+	info, err := parsewmpInfo(reply)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	limits := make(map[string][]string)
+	for _, fn := range _wmpLimits {
+		if reply, err = w.exchange(ctx, "LIMITS:"+fn, isLimits(fn)); err != nil {
+			return
+		}
+		// LIMITS:MODE,[AUTO,HEAT,DRY,FAN,COOL] or ERR when the unit doesn't have it
+		if v := strings.TrimPrefix(reply, "LIMITS:"+fn+","); v != reply {
+			limits[fn] = strings.Split(strings.Trim(v, "[]"), ",")
+		}
+	}
+	w.vmu.Lock()
+	w.info = info
+	w.limits = limits
+	w.vmu.Unlock()
+	return nil
+}
+
+// replies which answer each command
+func isID(reply string) bool  { return strings.HasPrefix(reply, "ID:") }
+func isAck(reply string) bool { return reply == "ACK" || reply == "ERR" }
+func isLimits(fn string) func(string) bool {
+	return func(reply string) bool { return strings.HasPrefix(reply, "LIMITS:"+fn) || reply == "ERR" }
+}
+
+// writes the line & waits for the reply which answers it, anything else is a stale reply
+// to an earlier command & skipped. a reply which doesn't turn up closes the connection
+// callers must hold w.mu
+func (w *wmp) exchange(ctx context.Context, line string, answers func(string) bool) (reply string, err error) {
+	deadline, bounded := replyDeadline(ctx)
+	if err = w.write(line, deadline); err != nil {
+		return
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case reply = <-w.replies:
+			if answers(reply) {
+				return
+			}
+			if w.ih.verbose {
+				fmt.Printf("DEBUG|wmp| skipping stale reply: %s\n", reply)
+			}
+		case <-w.done:
+			return "", newError(ErrUnreachable, "gateway hung up waiting for a reply to: %s", line)
+		case <-timer.C:
+			w.conn.Close()
+			if bounded {
+				return "", newError(ErrTimeout, "timed out waiting for a reply to: %s: %w", line, context.DeadlineExceeded)
+			}
+			return "", newError(ErrTimeout, "timed out waiting for a reply to: %s", line)
+		case <-ctx.Done():
+			w.conn.Close()
+			return "", fmt.Errorf("abandoned waiting on the gateway: %w", networkError(ctx.Err()))
+		}
+	}
+}
+
+// writes a line to the socket, callers must hold w.mu
+func (w *wmp) write(line string, deadline time.Time) error {
+	if w.ih.verbose {
+		fmt.Printf("DEBUG|wmp| sending: %s\n", line)
+	}
+	w.conn.SetWriteDeadline(deadline)
+	if _, err := w.conn.Write([]byte(line + "\r\n")); err != nil {
+		w.conn.Close()
+		return newError(ErrUnreachable, "socket write error: %w", err)
+	}
+	return nil
+}
+
+// reads lines until the connection drops, applying & publishing CHN frames as they arrive
+func (w *wmp) readLoop(conn net.Conn, replies chan string, done chan struct{}) {
+	defer close(done)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if w.ih.verbose {
+			fmt.Printf("DEBUG|wmp| received: %s\n", line)
+		}
+		if strings.HasPrefix(line, "CHN,") {
+			w.apply(line)
+			continue
+		}
+		select {
+		case replies <- line:
+		default:
+			// nobody is waiting on it & an older reply is still pending
+		}
+	}
+	conn.Close()
+}
+
+// records a CHN,1:FUNCTION,VALUE frame & publishes it when it's a function the client knows
+func (w *wmp) apply(line string) {
+	fields := strings.SplitN(strings.TrimPrefix(line, "CHN,"), ",", 2)
+	parts := strings.SplitN(fields[0], ":", 2)
+	if len(fields) != 2 || len(parts) != 2 {
+		if w.ih.verbose {
+			fmt.Printf("DEBUG|wmp| ignoring malformed frame: %s\n", line)
+		}
+		return
+	}
+	fn, value := parts[1], fields[1]
+	w.vmu.Lock()
+	w.values[fn] = value
+	id := w.info.id()
+	w.vmu.Unlock()
+	f, ok := _wmpFunctions[fn]
+	if !ok {
+		return
+	}
+	raw, ok := f.decode(w.ih.registry, value)
+	if !ok {
+		return
+	}
+	ev := StatusEvent{DeviceID: id, Uid: f.uid, Name: w.ih.registry.DecodeUid(f.uid), Value: raw}
+	ev.State = w.ih.registry.DecodeState(ev.Name, raw)
+	w.ih.publish(ev)
+}
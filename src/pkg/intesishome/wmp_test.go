@@ -0,0 +1,113 @@
+package intesishome_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/nullify005/service-intesis/pkg/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWMP(t *testing.T) {
+	device, _ := strconv.ParseInt(mock.DefaultWMPMAC, 16, 64)
+	newWMP := func(t *testing.T) (*intesishome.IntesisHome, *mock.WMPServer) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %v", err)
+		}
+		m := mock.NewWMPServer()
+		go m.Serve(l)
+		t.Cleanup(func() { l.Close(); m.Drop() })
+		ih := intesishome.New("", "", intesishome.WithWMP(l.Addr().String()), intesishome.WithSetDelay(0))
+		t.Cleanup(ih.Close)
+		return ih, m
+	}
+
+	t.Run("devices", func(t *testing.T) {
+		ih, _ := newWMP(t)
+		devices, err := ih.Devices()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(devices))
+		assert.Equal(t, strconv.FormatInt(device, 10), devices[0].ID)
+		assert.Equal(t, "MOCK", devices[0].Name)
+	})
+	t.Run("state", func(t *testing.T) {
+		ih, _ := newWMP(t)
+		state, err := ih.State(device)
+		assert.NoError(t, err)
+		assert.Equal(t, intesishome.PowerOn, *state.Power)
+		assert.Equal(t, intesishome.ModeCool, *state.Mode)
+		assert.Equal(t, 21.0, *state.Setpoint)
+		assert.Equal(t, 21.5, *state.Temperature)
+		assert.Equal(t, 18.0, *state.SetpointMin, "the SETPTEMP limits are the setpoint range")
+		assert.Equal(t, 30.0, *state.SetpointMax)
+		assert.Equal(t, -51, *state.Rssi)
+		assert.Nil(t, state.HVane, "the unit has no horizontal vanes")
+	})
+	t.Run("set", func(t *testing.T) {
+		ih, m := newWMP(t)
+		for _, c := range []struct {
+			key   string
+			value interface{}
+			fn    string
+			want  string
+		}{
+			{"power", "off", "ONOFF", "OFF"},
+			{"mode", "heat", "MODE", "HEAT"},
+			{"setpoint", 22.5, "SETPTEMP", "225"},
+			{"vvane", "swing", "VANEUD", "SWING"},
+			{"fan_speed", 2, "FANSP", "2"},
+		} {
+			uid, value, err := ih.MapCommand(device, c.key, c.value)
+			assert.NoError(t, err, c.key)
+			assert.NoError(t, ih.Set(device, uid, value), c.key)
+			v, _ := m.Value(c.fn)
+			assert.Equal(t, c.want, v, c.key)
+		}
+	})
+	t.Run("limits are validated", func(t *testing.T) {
+		ih, _ := newWMP(t)
+		_, _, err := ih.MapCommand(device, "setpoint", 31)
+		assert.ErrorIs(t, err, intesishome.ErrInvalidCommand)
+		_, _, err = ih.MapCommand(device, "vvane", "manual5")
+		assert.ErrorIs(t, err, intesishome.ErrInvalidCommand)
+	})
+	t.Run("refused", func(t *testing.T) {
+		ih, _ := newWMP(t)
+		_, err := ih.Devices()
+		assert.NoError(t, err)
+		err = ih.Set(device, 9, 400)
+		assert.ErrorIs(t, err, intesishome.ErrInvalidCommand)
+		assert.ErrorContains(t, err, "SET,1:SETPTEMP,400 got: ERR")
+		assert.ErrorIs(t, ih.Set(device, 14, 1), intesishome.ErrInvalidCommand, "alarm_status has no WMP function")
+	})
+	t.Run("changes are pushed", func(t *testing.T) {
+		ih, m := newWMP(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		events, err := ih.Subscribe(ctx)
+		assert.NoError(t, err)
+		m.Push("AMBTEMP", "-15")
+		select {
+		case ev := <-events:
+			assert.Equal(t, device, ev.DeviceID)
+			assert.Equal(t, "temperature", ev.Name)
+			assert.Equal(t, -1.5, ev.State)
+		case <-ctx.Done():
+			t.Fatal("no push arrived")
+		}
+	})
+	t.Run("reconnects", func(t *testing.T) {
+		ih, m := newWMP(t)
+		_, err := ih.Devices()
+		assert.NoError(t, err)
+		m.Drop()
+		time.Sleep(50 * time.Millisecond)
+		_, err = ih.Devices()
+		assert.NoError(t, err)
+	})
+}
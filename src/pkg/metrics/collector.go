@@ -0,0 +1,172 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const _namespace string = "hvac"
+
+// the labels carried by every per device series
+var _deviceLabels = []string{"device_id", "device_name", "installation"}
+
+// the devices as they are at scrape time, such as the watcher's latest snapshot
+type Source func() []intesishome.DeviceStatus
+
+// exposes every numeric uid of every device as a gauge labelled with the device
+// along with hvac_device_info. the gauges are named after the uid & its unit
+// within the mapping registry, so temperature is hvac_temperature_celsius.
+// uids whose values are names are state sets instead, one series per name with
+// the current one at 1, so hvac_mode{mode="cool"} 1 & hvac_mode{mode="heat"} 0.
+// it also observes how the exporter itself is doing, see ObservePoll & Middleware
+type Collector struct {
+	source     Source
+	registerer prometheus.Registerer
+	registry   *intesishome.Registry // the mappings the uids are named after
+	info       *prometheus.Desc
+	gauges     map[string]*prometheus.Desc // uid names to their gauge
+	states     map[string]*prometheus.Desc // uid names to their state set
+	observed
+}
+
+// builds a Collector over source, describing nothing until its uids are known via describeUids
+func newCollector(source Source) *Collector {
+	return &Collector{
+		source:   source,
+		registry: intesishome.DefaultRegistry,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(_namespace, "device", "info"),
+			"HVAC device model & family, always 1",
+			append(_deviceLabels, "model", "family"), nil,
+		),
+		gauges:   make(map[string]*prometheus.Desc),
+		states:   make(map[string]*prometheus.Desc),
+		observed: newObserved(),
+	}
+}
+
+// a gauge or state set for every uid known to the registry, so any further mappings
+// must be loaded beforehand
+func (c *Collector) describeUids() {
+	for _, e := range c.registry.States() {
+		_, gauge := c.gauges[e.Name]
+		if _, state := c.states[e.Name]; gauge || state {
+			// the first uid carrying the name wins, as it does for lookups by name
+			continue
+		}
+		if e.Named() {
+			c.states[e.Name] = prometheus.NewDesc(
+				prometheus.BuildFQName(_namespace, "", e.Name),
+				fmt.Sprintf("HVAC %s (uid %v), 1 for the current state", strings.ReplaceAll(e.Name, "_", " "), e.Uid),
+				append(_deviceLabels, e.Name), nil,
+			)
+			continue
+		}
+		c.gauges[e.Name] = prometheus.NewDesc(gaugeName(e), gaugeHelp(e), _deviceLabels, nil)
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	for _, d := range c.gauges {
+		ch <- d
+	}
+	for _, d := range c.states {
+		ch <- d
+	}
+	c.observed.describe(ch)
+}
+
+// unavailable values are skipped, as are uids the registry doesn't know
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.observed.collect(ch)
+	for _, d := range c.source() {
+		labels := []string{d.Device.ID, d.Device.Name, d.Installation.Name}
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1,
+			append(labels, strconv.Itoa(d.Device.ModelID), strconv.Itoa(d.Device.FamilyID))...,
+		)
+		for name, raw := range d.State.Raw {
+			if desc, ok := c.states[name]; ok {
+				current := fmt.Sprint(raw)
+				if v := d.DecodeState(name, raw); v != nil {
+					current = fmt.Sprint(v)
+				}
+				for _, state := range states(c.registry, d, name, current) {
+					v := 0.0
+					if state == current {
+						v = 1
+					}
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append(labels, state)...)
+				}
+				continue
+			}
+			desc, ok := c.gauges[name]
+			if !ok {
+				continue
+			}
+			e, _ := c.registry.Name(name)
+			v, ok := number(e.Decode(raw))
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+		}
+	}
+}
+
+// every state of the named uid in order, those the device's profile names or failing that
+// those within the state map. the current state is always amongst them, as its raw value
+// when it has no name
+func states(r *intesishome.Registry, d intesishome.DeviceStatus, name, current string) []string {
+	names := map[string]bool{current: true}
+	if p := d.Profile.Names(name); p != nil {
+		for _, n := range p {
+			names[n] = true
+		}
+	} else {
+		e, _ := r.Name(name)
+		for _, v := range e.Values {
+			if n, ok := v.(string); ok {
+				names[n] = true
+			}
+		}
+	}
+	states := make([]string, 0, len(names))
+	for n := range names {
+		states = append(states, n)
+	}
+	sort.Strings(states)
+	return states
+}
+
+// hvac_ followed by the uid name & its unit, unless the name already ends with it
+func gaugeName(e intesishome.StateEntry) string {
+	if e.Unit == "" || strings.HasSuffix(e.Name, "_"+e.Unit) {
+		return prometheus.BuildFQName(_namespace, "", e.Name)
+	}
+	return prometheus.BuildFQName(_namespace, e.Name, e.Unit)
+}
+
+func gaugeHelp(e intesishome.StateEntry) string {
+	help := fmt.Sprintf("HVAC %s (uid %v)", strings.ReplaceAll(e.Name, "_", " "), e.Uid)
+	if e.Unit != "" {
+		help += " in " + e.Unit
+	}
+	return help
+}
+
+// the decoded value as a float, ok is false for names & unavailable values
+func number(v interface{}) (f float64, ok bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return
+}
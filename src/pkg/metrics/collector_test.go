@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	devices := []intesishome.DeviceStatus{
+		{
+			Device:       intesishome.Device{ID: "127934703953", Name: "Lounge", FamilyID: 3840, ModelID: 79},
+			Installation: intesishome.Installation{Name: "Home"},
+			State: intesishome.NewDeviceState(map[string]interface{}{
+				"power":                     1,
+				"mode":                      4,
+				"fan_speed":                 2,
+				"setpoint":                  32768,
+				"temperature":               215,
+				"outdoor_temp":              65531,
+				"working_hours":             1234,
+				"instant_power_consumption": 850,
+				"rssi":                      -52,
+			}),
+		},
+		{
+			Device:       intesishome.Device{ID: "227934703955", Name: "Server Room", FamilyID: 3840, ModelID: 80},
+			Installation: intesishome.Installation{Name: "Office"},
+			State:        intesishome.NewDeviceState(map[string]interface{}{"temperature": 190}),
+		},
+	}
+	registry := prometheus.NewRegistry()
+	_, err := New(func() []intesishome.DeviceStatus { return devices }, WithRegisterer(registry))
+	assert.NoError(t, err)
+	body := scrape(t, registry)
+
+	lounge := `{device_id="127934703953",device_name="Lounge",installation="Home"}`
+	for _, s := range []string{
+		`hvac_device_info{device_id="127934703953",device_name="Lounge",family="3840",installation="Home",model="79"} 1`,
+		`hvac_device_info{device_id="227934703955",device_name="Server Room",family="3840",installation="Office",model="80"} 1`,
+		"hvac_temperature_celsius" + lounge + " 21.5",
+		`hvac_temperature_celsius{device_id="227934703955",device_name="Server Room",installation="Office"} 19`,
+		"hvac_outdoor_temp_celsius" + lounge + " -0.5",
+		// without a profile the fan speed has no names, so it's the raw value
+		`hvac_fan_speed{device_id="127934703953",device_name="Lounge",fan_speed="2",installation="Home"} 1`,
+		"hvac_working_hours" + lounge + " 1234",
+		"hvac_instant_power_consumption_watts" + lounge + " 850",
+		"hvac_rssi" + lounge + " -52",
+		"# HELP hvac_temperature_celsius HVAC temperature (uid 10) in celsius",
+	} {
+		assert.Contains(t, body, s)
+	}
+	// uids decoding to names aren't gauges & unavailable values aren't reported
+	assert.NotContains(t, body, "hvac_mode"+lounge)
+	assert.NotContains(t, body, "hvac_fan_speed"+lounge)
+	assert.NotContains(t, body, "hvac_setpoint_celsius"+lounge)
+}
+
+func TestCollectorStateSets(t *testing.T) {
+	snapshot := func(t *testing.T) []intesishome.DeviceStatus {
+		// model 79 has a profile with every mode & fan speeds auto to high
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "../intesishome/assets/tests/multiDeviceControlResponse.json")
+		}))
+		defer s.Close()
+		snapshot, err := intesishome.New("u", "p", intesishome.WithHostname(s.URL)).StatusAll()
+		if err != nil {
+			t.Fatalf("unable to obtain the snapshot: %v", err.Error())
+		}
+		d, _ := snapshot.Device(127934703953)
+		d.State.Set("mode", 4)
+		d.State.Set("fan_speed", 3)
+		d.State.Set("vvane", 99)
+		return []intesishome.DeviceStatus{d}
+	}(t)
+	registry := prometheus.NewRegistry()
+	_, err := New(func() []intesishome.DeviceStatus { return snapshot }, WithRegisterer(registry))
+	assert.NoError(t, err)
+	body := scrape(t, registry)
+
+	// labels are exposed in order
+	series := func(name, state string, v int) string {
+		labels := []string{`device_id="127934703953"`, `device_name="Lounge"`, `installation="Home"`, fmt.Sprintf(`%s="%s"`, name, state)}
+		sort.Strings(labels)
+		return fmt.Sprintf("hvac_%s{%s} %v", name, strings.Join(labels, ","), v)
+	}
+	for _, s := range []string{
+		series("mode", "cool", 1),
+		series("mode", "heat", 0),
+		series("mode", "auto", 0),
+		series("power", "on", 1),
+		series("power", "off", 0),
+		series("fan_speed", "medium", 1),
+		series("fan_speed", "quiet", 0),
+		// a value without a name is its own state
+		series("vvane", "99", 1),
+		series("vvane", "swing", 0),
+		"# HELP hvac_mode HVAC mode (uid 2), 1 for the current state",
+	} {
+		assert.Contains(t, body, s)
+	}
+}
+
+func TestCollectorMappings(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "../intesishome/assets/tests/validControlResponse.json")
+	}))
+	defer s.Close()
+	ih := intesishome.New("u", "p", intesishome.WithHostname(s.URL),
+		intesishome.WithMappings("../intesishome/assets/tests/mappingOverlay.yaml"))
+	snapshot, err := ih.StatusAll()
+	if err != nil {
+		t.Fatalf("unable to obtain the snapshot: %v", err.Error())
+	}
+	d, _ := snapshot.Device(127934703953)
+	d.State.Set("night_mode", 1)
+	registry := prometheus.NewRegistry()
+	_, err = New(func() []intesishome.DeviceStatus { return []intesishome.DeviceStatus{d} },
+		WithRegisterer(registry), WithMappings(ih.Registry()))
+	assert.NoError(t, err)
+	// the uid is only known to the client's mappings
+	assert.Contains(t, scrape(t, registry),
+		`hvac_night_mode{device_id="127934703953",device_name="Home AC Unit",installation="First installation",night_mode="on"} 1`)
+}
@@ -1,80 +1,38 @@
 package metrics
 
 import (
-	"sync"
-
+	"github.com/nullify005/service-intesis/pkg/intesishome"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	m     *metrics
-	mTemp = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "hvac_temperature_celcius",
-		Help: "HVAC observed temperature in celcius",
-	})
-	mSetPoint = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "hvac_setpoint_celcius",
-		Help: "HVAC desired temperature in celcius",
-	})
-	mPower = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "hvac_power_state",
-		Help: "HVAC power state 0: off 1: on",
-	})
-	mMode = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "hvac_mode_state",
-		Help: "HVAC mode state 0: auto 1: heat 2: dry 3: fan 4: cool",
-	})
-)
-
-// the exposed interface
-type Metrics interface {
-	Temperature(float64)
-	SetPoint(float64)
-	Power(float64)
-	Mode(float64)
-}
+type Option func(c *Collector)
 
-// the implementation of it along with the internal state
-type metrics struct {
-	mu sync.Mutex
-}
-
-// NOTE: the http handler still needs to be registered elsewhere
-func New() *metrics {
-	if m == nil {
-		m = &metrics{}
-		prometheus.MustRegister(mTemp)
-		prometheus.MustRegister(mSetPoint)
-		prometheus.MustRegister(mPower)
-		prometheus.MustRegister(mMode)
-		mTemp.Set(20)
-		mSetPoint.Set(20)
-		mPower.Set(0)
-		mMode.Set(0)
+// the registerer the Collector is registered with, rather than the default one
+func WithRegisterer(r prometheus.Registerer) Option {
+	return func(c *Collector) {
+		c.registerer = r
 	}
-	return m
 }
 
-func (m *metrics) Temperature(t float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	mTemp.Set(t)
-}
-
-func (m *metrics) SetPoint(t float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	mSetPoint.Set(t)
-}
-
-func (m *metrics) Power(t float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	mPower.Set(t)
+// the mappings the gauges & state sets are named after, such as the client's own Registry,
+// rather than DefaultRegistry
+func WithMappings(r *intesishome.Registry) Option {
+	return func(c *Collector) {
+		c.registry = r
+	}
 }
 
-func (m *metrics) Mode(t float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	mMode.Set(t)
+// builds the Collector over source & registers it. nothing is reported for a device
+// until source has it, so there's nothing to scrape before the first poll
+func New(source Source, opts ...Option) (*Collector, error) {
+	c := newCollector(source)
+	c.registerer = prometheus.DefaultRegisterer
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.describeUids()
+	if err := c.registerer.Register(c); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
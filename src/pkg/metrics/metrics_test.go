@@ -3,33 +3,63 @@ package metrics
 import (
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/assert"
 )
 
-var requiredMetrics = []string{
-	"hvac_mode_state 2",
-	"hvac_power_state 1",
-	"hvac_setpoint_celcius 10",
-	"hvac_temperature_celcius 10",
-}
-
 const metricsPath string = "/metrics"
 
-func TestMetrics(t *testing.T) {
-	m := New()
-	m.SetPoint(10)
-	m.Temperature(10)
-	m.Mode(2)
-	m.Power(1)
-	request := httptest.NewRequest(http.MethodGet, metricsPath, nil)
+// the exposition of everything within the registry
+func scrape(t *testing.T, g prometheus.Gatherer) string {
 	recorder := httptest.NewRecorder()
-	promhttp.Handler().ServeHTTP(recorder, request)
-	assert.Equal(t, recorder.Code, http.StatusOK)
-	body := recorder.Body.String()
-	for _, s := range requiredMetrics {
-		assert.Contains(t, body, s)
-	}
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	return recorder.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("read at scrape time", func(t *testing.T) {
+		var (
+			devices []intesishome.DeviceStatus
+			mu      sync.Mutex
+		)
+		registry := prometheus.NewRegistry()
+		_, err := New(func() []intesishome.DeviceStatus {
+			mu.Lock()
+			defer mu.Unlock()
+			return devices
+		}, WithRegisterer(registry))
+		assert.NoError(t, err)
+		assert.NotContains(t, scrape(t, registry), "device_id", "nothing for the devices before the first poll")
+
+		mu.Lock()
+		devices = []intesishome.DeviceStatus{{
+			Device: intesishome.Device{ID: "127934703953", Name: "Lounge"},
+			State:  intesishome.NewDeviceState(map[string]interface{}{"temperature": 215}),
+		}}
+		mu.Unlock()
+		assert.Contains(t, scrape(t, registry), `hvac_temperature_celsius{device_id="127934703953",device_name="Lounge",installation=""} 21.5`)
+	})
+	t.Run("independent registries", func(t *testing.T) {
+		t.Parallel()
+		none := func() []intesishome.DeviceStatus { return nil }
+		for i := 0; i < 2; i++ {
+			_, err := New(none, WithRegisterer(prometheus.NewRegistry()))
+			assert.NoError(t, err)
+		}
+	})
+	t.Run("registered once per registry", func(t *testing.T) {
+		t.Parallel()
+		none := func() []intesishome.DeviceStatus { return nil }
+		registry := prometheus.NewRegistry()
+		_, err := New(none, WithRegisterer(registry))
+		assert.NoError(t, err)
+		_, err = New(none, WithRegisterer(registry))
+		assert.Error(t, err)
+	})
 }
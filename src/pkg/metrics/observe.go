@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// how the exporter itself is doing: its polls, its dealings with the Intesis Cloud
+// & the TCP gateway & the requests made of its own HTTP API
+type observed struct {
+	polls       prometheus.Counter
+	failures    *prometheus.CounterVec   // polls by error class
+	lastRefresh prometheus.Gauge         // unix time of the last successful poll
+	stateAge    *prometheus.Desc         // seconds since the last successful poll, at scrape time
+	requests    *prometheus.HistogramVec // control endpoint requests by outcome
+	sets        *prometheus.HistogramVec // TCP gateway sets by outcome
+	tokens      *prometheus.CounterVec   // token refreshes by outcome
+	api         *prometheus.HistogramVec // HTTP API requests by method, route & status
+	refreshed   time.Time
+	mu          sync.Mutex
+}
+
+func newObserved() observed {
+	return observed{
+		polls: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: _namespace, Name: "polls_total",
+			Help: "HVAC state polls",
+		}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: _namespace, Name: "poll_failures_total",
+			Help: "HVAC state polls which failed, by error class",
+		}, []string{"class"}),
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: _namespace, Name: "last_refresh_timestamp_seconds",
+			Help: "unix time of the last successful HVAC state poll",
+		}),
+		stateAge: prometheus.NewDesc(
+			prometheus.BuildFQName(_namespace, "state", "age_seconds"),
+			"seconds since the last successful HVAC state poll", nil, nil,
+		),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: _namespace, Name: "cloud_request_duration_seconds",
+			Help:    "Intesis Cloud control endpoint latency, by outcome",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		sets: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: _namespace, Name: "gateway_set_duration_seconds",
+			Help:    "TCP gateway set latency from writing the set to its ack, by outcome",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: _namespace, Name: "token_refreshes_total",
+			Help: "TCP gateway tokens obtained from the Intesis Cloud, by outcome",
+		}, []string{"outcome"}),
+		api: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: _namespace, Name: "api_request_duration_seconds",
+			Help:    "HTTP API latency, by method, route & status",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+}
+
+func (o *observed) describe(ch chan<- *prometheus.Desc) {
+	o.polls.Describe(ch)
+	o.failures.Describe(ch)
+	o.lastRefresh.Describe(ch)
+	ch <- o.stateAge
+	o.requests.Describe(ch)
+	o.sets.Describe(ch)
+	o.tokens.Describe(ch)
+	o.api.Describe(ch)
+}
+
+// the state age is only there once a poll has succeeded
+func (o *observed) collect(ch chan<- prometheus.Metric) {
+	o.polls.Collect(ch)
+	o.failures.Collect(ch)
+	o.lastRefresh.Collect(ch)
+	o.mu.Lock()
+	refreshed := o.refreshed
+	o.mu.Unlock()
+	if !refreshed.IsZero() {
+		ch <- prometheus.MustNewConstMetric(o.stateAge, prometheus.GaugeValue, time.Since(refreshed).Seconds())
+	}
+	o.requests.Collect(ch)
+	o.sets.Collect(ch)
+	o.tokens.Collect(ch)
+	o.api.Collect(ch)
+}
+
+// counts a poll of the state, err is nil when it succeeded
+func (c *Collector) ObservePoll(err error) {
+	c.polls.Inc()
+	if err != nil {
+		c.failures.WithLabelValues(class(err)).Inc()
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	c.refreshed = now
+	c.mu.Unlock()
+	c.lastRefresh.Set(float64(now.UnixNano()) / 1e9)
+}
+
+// see intesishome.Observer
+func (c *Collector) ObserveRequest(d time.Duration, err error) {
+	c.requests.WithLabelValues(outcome(err)).Observe(d.Seconds())
+}
+
+// see intesishome.Observer
+func (c *Collector) ObserveSet(d time.Duration, err error) {
+	c.sets.WithLabelValues(outcome(err)).Observe(d.Seconds())
+}
+
+// see intesishome.Observer
+func (c *Collector) ObserveTokenRefresh(err error) {
+	c.tokens.WithLabelValues(outcome(err)).Inc()
+}
+
+// times each request of the HTTP API, routes are as registered so /hvac/:device
+// rather than each device
+func (c *Collector) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		c.api.WithLabelValues(ctx.Request.Method, route, strconv.Itoa(ctx.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ok or the class of the failure
+func outcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return class(err)
+}
+
+// the category of a failure from the Intesis Home client
+func class(err error) string {
+	var (
+		cloudErr *intesishome.CloudError
+		localErr *intesishome.LocalError
+		httpErr  *intesishome.HTTPError
+	)
+	switch {
+	case errors.Is(err, intesishome.ErrTokenRejected):
+		return "token_rejected"
+	case errors.Is(err, intesishome.ErrDeviceNotFound):
+		return "device_not_found"
+	case errors.Is(err, intesishome.ErrInvalidCommand):
+		return "invalid_command"
+	case errors.Is(err, intesishome.ErrTimeout):
+		return "timeout"
+	case errors.Is(err, intesishome.ErrUnreachable):
+		return "unreachable"
+	case errors.Is(err, intesishome.ErrProtocol):
+		return "protocol"
+	case errors.As(err, &cloudErr):
+		return "cloud"
+	case errors.As(err, &localErr):
+		return "local"
+	case errors.As(err, &httpErr):
+		return "http"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	}
+	return "other"
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("wrapped: %w", intesishome.ErrTokenRejected), "token_rejected"},
+		{intesishome.ErrTimeout, "timeout"},
+		{intesishome.ErrUnreachable, "unreachable"},
+		{&intesishome.HTTPError{StatusCode: http.StatusBadGateway}, "unreachable"},
+		{&intesishome.HTTPError{StatusCode: http.StatusForbidden}, "http"},
+		{&intesishome.CloudError{Code: 1}, "cloud"},
+		{&intesishome.LocalError{Code: 3}, "local"},
+		{intesishome.ErrProtocol, "protocol"},
+		{context.Canceled, "cancelled"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, class(tt.err))
+		})
+	}
+	assert.Equal(t, "ok", outcome(nil))
+}
+
+func TestObserved(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	c, err := New(func() []intesishome.DeviceStatus { return nil }, WithRegisterer(registry))
+	assert.NoError(t, err)
+	var _ intesishome.Observer = c
+
+	assert.NotContains(t, scrape(t, registry), "hvac_state_age_seconds", "no age before the first poll")
+	c.ObservePoll(intesishome.ErrTimeout)
+	c.ObservePoll(&intesishome.CloudError{Code: 1})
+	c.ObservePoll(nil)
+	c.ObserveRequest(50*time.Millisecond, nil)
+	c.ObserveRequest(time.Second, intesishome.ErrUnreachable)
+	c.ObserveSet(200*time.Millisecond, nil)
+	c.ObserveTokenRefresh(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(c.Middleware())
+	router.GET("/hvac/:device", func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") })
+	for _, path := range []string{"/hvac/1", "/hvac/2", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t, registry)
+	for _, s := range []string{
+		"hvac_polls_total 3",
+		`hvac_poll_failures_total{class="timeout"} 1`,
+		`hvac_poll_failures_total{class="cloud"} 1`,
+		`hvac_cloud_request_duration_seconds_count{outcome="ok"} 1`,
+		`hvac_cloud_request_duration_seconds_count{outcome="unreachable"} 1`,
+		`hvac_gateway_set_duration_seconds_count{outcome="ok"} 1`,
+		`hvac_token_refreshes_total{outcome="ok"} 1`,
+		`hvac_api_request_duration_seconds_count{method="GET",route="/hvac/:device",status="200"} 2`,
+		`hvac_api_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		assert.Contains(t, body, s)
+	}
+	assert.Regexp(t, regexp.MustCompile(`(?m)^hvac_state_age_seconds [0-9.e+-]+$`), body)
+	assert.NotContains(t, body, "hvac_last_refresh_timestamp_seconds 0\n")
+}
@@ -2,6 +2,8 @@ package mock
 
 import (
 	_ "embed"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 
@@ -50,7 +52,29 @@ func (h *HTTPServer) Run() {
 	log.Fatal(router.Run(h.Listen))
 }
 
+// answers with only the section hashes when the client already has the latest of both
 func handleEndpoint(c *gin.Context) {
+	var (
+		payload intesishome.ControlResponse
+		cmd     struct {
+			Status struct {
+				Hash string `json:"hash"`
+			} `json:"status"`
+			Config struct {
+				Hash string `json:"hash"`
+			} `json:"config"`
+		}
+	)
+	if err := json.Unmarshal(_responsePayload, &payload); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := json.Unmarshal([]byte(c.PostForm("cmd")), &cmd); err == nil &&
+		cmd.Status.Hash == payload.Status.Hash && cmd.Config.Hash == payload.Config.Hash {
+		c.String(http.StatusOK, fmt.Sprintf(`{"config":{"hash":"%s","token":%v},"status":{"hash":"%s"}}`,
+			payload.Config.Hash, payload.Config.Token, payload.Status.Hash))
+		return
+	}
 	c.String(http.StatusOK, string(_responsePayload))
 }
 
@@ -0,0 +1,185 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+)
+
+const (
+	DefaultLocalListen   string = "127.0.0.1:5002"
+	DefaultLocalUsername string = "admin"
+	DefaultLocalPassword string = "admin"
+	DefaultLocalSN       string = "127934703953" // the same device as the cloud mock
+)
+
+// local api error codes, 1 has the client log in again
+const (
+	localErrSession int = 1
+	localErrCommand int = 2
+	localErrLogin   int = 3
+	localErrValue   int = 4
+)
+
+type LocalOption func(l *LocalServer)
+
+// emulates the HTTP API of a WMP device on the local network
+type LocalServer struct {
+	Listen   string
+	Username string
+	Password string
+	Info     intesishome.LocalInfo
+	values   map[int]int // uids to their raw value
+	sessions map[string]bool
+	mu       sync.Mutex
+}
+
+// set the host:port to listen on
+func WithLocalListen(l string) LocalOption {
+	return func(s *LocalServer) {
+		s.Listen = DefaultLocalListen
+		if l != "" {
+			s.Listen = l
+		}
+	}
+}
+
+// the credentials the device accepts
+func WithLocalCredentials(user, pass string) LocalOption {
+	return func(s *LocalServer) {
+		s.Username = user
+		s.Password = pass
+	}
+}
+
+// builds a LocalServer with the same values as the cloud mock's device
+func NewLocalServer(opts ...LocalOption) *LocalServer {
+	s := LocalServer{
+		Listen:   DefaultLocalListen,
+		Username: DefaultLocalUsername,
+		Password: DefaultLocalPassword,
+		Info: intesishome.LocalInfo{
+			DeviceModel: "INWMPUNI001I000",
+			SN:          DefaultLocalSN,
+			OwnSSID:     "DEVICE_AABBCC",
+			MAC:         "CC:3F:1D:AA:BB:CC",
+			FwVersion:   "1.3.3",
+			Rssi:        -52,
+		},
+		values:   make(map[int]int),
+		sessions: make(map[string]bool),
+	}
+	var payload intesishome.ControlResponse
+	if err := json.Unmarshal(_responsePayload, &payload); err != nil {
+		panic(err)
+	}
+	for _, st := range payload.Status.Status {
+		if fmt.Sprint(st.DeviceID) == DefaultLocalSN {
+			s.values[st.UID] = st.Value
+		}
+	}
+	for _, o := range opts {
+		o(&s)
+	}
+	return &s
+}
+
+// the raw value of a uid
+func (s *LocalServer) Value(uid int) (v int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok = s.values[uid]
+	return
+}
+
+// forgets every session, as the device does when it restarts
+func (s *LocalServer) Expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]bool)
+}
+
+// the api.cgi handler
+func (s *LocalServer) Handler() http.Handler {
+	router := gin.New()
+	router.POST(intesishome.LocalEndpoint, s.handle)
+	router.GET(healthPath, handleHealth)
+	return router
+}
+
+func (s *LocalServer) Run() {
+	log.Fatal(http.ListenAndServe(s.Listen, s.Handler()))
+}
+
+func (s *LocalServer) handle(c *gin.Context) {
+	var req struct {
+		Command string `json:"command"`
+		Data    struct {
+			SessionID string      `json:"sessionID"`
+			Username  string      `json:"username"`
+			Password  string      `json:"password"`
+			Uid       interface{} `json:"uid"`
+			Value     int         `json:"value"`
+		} `json:"data"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		localFailure(c, localErrCommand, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Command == "login" {
+		if req.Data.Username != s.Username || req.Data.Password != s.Password {
+			localFailure(c, localErrLogin, "invalid username or password")
+			return
+		}
+		id := uuid.NewString()
+		s.sessions[id] = true
+		localSuccess(c, gin.H{"id": gin.H{"sessionID": id}})
+		return
+	}
+	if !s.sessions[req.Data.SessionID] {
+		localFailure(c, localErrSession, "invalid session id")
+		return
+	}
+	switch req.Command {
+	case "getinfo":
+		localSuccess(c, gin.H{"info": s.Info})
+	case "getdatapointvalue":
+		uids := make([]int, 0, len(s.values))
+		for uid := range s.values {
+			uids = append(uids, uid)
+		}
+		sort.Ints(uids)
+		values := make([]gin.H, 0, len(uids))
+		for _, uid := range uids {
+			values = append(values, gin.H{"uid": uid, "value": s.values[uid], "status": 0})
+		}
+		localSuccess(c, gin.H{"dpval": values})
+	case "setdatapointvalue":
+		uid, ok := req.Data.Uid.(float64)
+		if !ok {
+			localFailure(c, localErrValue, fmt.Sprintf("invalid uid: %v", req.Data.Uid))
+			return
+		}
+		s.values[int(uid)] = req.Data.Value
+		localSuccess(c, gin.H{})
+	default:
+		localFailure(c, localErrCommand, "unknown command: "+req.Command)
+	}
+}
+
+func localSuccess(c *gin.Context, data gin.H) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+}
+
+func localFailure(c *gin.Context, code int, message string) {
+	c.JSON(http.StatusOK, gin.H{"success": false, "error": gin.H{"code": code, "message": message}})
+}
@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"net"
@@ -27,6 +26,7 @@ type Conn struct {
 	t              uuid.UUID
 	c              net.Conn
 	readLimitBytes int
+	readTimeout    time.Duration
 	authenticated  bool
 }
 
@@ -93,6 +93,7 @@ func (t *TCPServer) Run() error {
 		c.c = conn
 		c.t = uuid.New()
 		c.readLimitBytes = t.ReadLimitBytes
+		c.readTimeout = t.ReadTimeout
 		c.c.SetDeadline(time.Now().Add(t.ReadTimeout))
 		go handleConn(&c)
 	}
@@ -127,13 +128,16 @@ func jsonPayload(p string) (string, error) {
 }
 
 // TODO: compress this
+// sessions are long lived so the read limit applies per payload & each
+// payload (including keepalives) pushes the read deadline out again
 func handleConn(c *Conn) {
 	defer c.c.Close()
-	limit := &io.LimitedReader{R: c.c, N: int64(c.readLimitBytes)}
 	log.Printf("(%s) received connection from: %s", c.t, c.c.RemoteAddr().String())
-	s := bufio.NewScanner(limit)
+	s := bufio.NewScanner(c.c)
+	s.Buffer(make([]byte, 0, c.readLimitBytes), c.readLimitBytes)
 	s.Split(splitDoubleEndBrace)
 	for s.Scan() {
+		c.c.SetDeadline(time.Now().Add(c.readTimeout))
 		j, err := jsonPayload(s.Text())
 		if err != nil {
 			log.Printf("(%s) ignoring invalid payload: %s", c.t, s.Text())
@@ -194,12 +198,20 @@ func handlePayload(c *Conn, p string) {
 			return
 		}
 		response.Data.DeviceID = request.Data.DeviceID
+		response.Data.SeqNo = request.Data.SeqNo
 		r, err := json.Marshal(response)
 		if err != nil {
 			log.Printf("(%s) cannot marshal response: %s", c.t, err.Error())
 			return
 		}
 		c.c.Write(r)
+		// the real thing then pushes the changed state to authenticated connections
+		push := fmt.Sprintf(`{"command":"status","data":{"deviceId":%v,"uid":%v,"value":%v}}`,
+			request.Data.DeviceID, request.Data.Uid, request.Data.Value)
+		c.c.Write([]byte(push))
+	case "get":
+		// keepalive, the real thing doesn't answer these
+		log.Printf("(%s) keepalive for device: %v", c.t, request.Data.DeviceID)
 	default:
 		log.Printf("(%s) ignoring invalid payload, command was empty: %s", c.t, p)
 		return
@@ -0,0 +1,225 @@
+package mock
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultWMPListen string = "127.0.0.1:3310"
+	DefaultWMPMAC    string = "001DC9A183E1"
+	wmpAC            string = "1"
+)
+
+type WMPOption func(w *WMPServer)
+
+// emulates an IntesisBox gateway speaking WMP, changes are pushed to every connection as CHN frames
+type WMPServer struct {
+	Listen string
+	Name   string
+	values map[string]string   // function to its value
+	limits map[string][]string // function to the values it allows, SETPTEMP is a [min,max] range
+	conns  map[net.Conn]bool
+	mu     sync.Mutex
+}
+
+// set the host:port to listen on
+func WithWMPListen(l string) WMPOption {
+	return func(w *WMPServer) {
+		w.Listen = DefaultWMPListen
+		if l != "" {
+			w.Listen = l
+		}
+	}
+}
+
+// builds a WMPServer for a unit without horizontal vanes
+func NewWMPServer(opts ...WMPOption) *WMPServer {
+	w := WMPServer{
+		Listen: DefaultWMPListen,
+		Name:   "MOCK",
+		values: map[string]string{
+			"ONOFF":     "ON",
+			"MODE":      "COOL",
+			"FANSP":     "AUTO",
+			"VANEUD":    "AUTO",
+			"SETPTEMP":  "210",
+			"AMBTEMP":   "215",
+			"ERRSTATUS": "OK",
+			"ERRCODE":   "0",
+		},
+		limits: map[string][]string{
+			"ONOFF":    {"OFF", "ON"},
+			"MODE":     {"AUTO", "HEAT", "DRY", "FAN", "COOL"},
+			"FANSP":    {"AUTO", "1", "2", "3", "4"},
+			"VANEUD":   {"AUTO", "1", "2", "3", "SWING"},
+			"SETPTEMP": {"180", "300"},
+		},
+		conns: make(map[net.Conn]bool),
+	}
+	for _, o := range opts {
+		o(&w)
+	}
+	return &w
+}
+
+// the value of a function
+func (w *WMPServer) Value(fn string) (v string, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	v, ok = w.values[fn]
+	return
+}
+
+// changes a function as though the unit did & pushes it to every connection
+func (w *WMPServer) Push(fn, value string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.values[fn] = value
+	w.broadcast(chn(fn, value))
+}
+
+// listens on Listen & serves connections until the listener fails
+func (w *WMPServer) Run() error {
+	l, err := net.Listen("tcp4", w.Listen)
+	if err != nil {
+		return err
+	}
+	return w.Serve(l)
+}
+
+// serves connections from l until it's closed
+func (w *WMPServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.conns[conn] = true
+		w.mu.Unlock()
+		go w.handle(conn)
+	}
+}
+
+// hangs up on every connection, as the gateway does when it restarts
+func (w *WMPServer) Drop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for conn := range w.conns {
+		conn.Close()
+	}
+}
+
+func (w *WMPServer) handle(conn net.Conn) {
+	defer func() {
+		w.mu.Lock()
+		delete(w.conns, conn)
+		w.mu.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		w.mu.Lock()
+		replies := w.reply(conn, line)
+		for _, r := range replies {
+			fmt.Fprintf(conn, "%s\r\n", r)
+		}
+		w.mu.Unlock()
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("wmp connection error: %v", err)
+	}
+}
+
+// the replies to a line, SET also pushes the change to every other connection. callers must hold w.mu
+func (w *WMPServer) reply(conn net.Conn, line string) []string {
+	switch {
+	case line == "ID":
+		return []string{fmt.Sprintf("ID:IS-IR-WMP-1,%s,127.0.0.1,ASCII,v1.0.1,-51,%s,N,1", DefaultWMPMAC, w.Name)}
+	case line == "INFO":
+		return []string{
+			"INFO:RUNVERSION,1.0.1",
+			"INFO:CFGVERSION,1.0.0",
+			"INFO:DEVICENAME," + w.Name,
+			"INFO:WIFI,-51",
+		}
+	case line == "PING":
+		return []string{"PONG:-51"}
+	case strings.HasPrefix(line, "LIMITS:"):
+		fn := strings.TrimPrefix(line, "LIMITS:")
+		if l, ok := w.limits[fn]; ok {
+			return []string{fmt.Sprintf("LIMITS:%s,[%s]", fn, strings.Join(l, ","))}
+		}
+		return []string{"ERR"}
+	case strings.HasPrefix(line, "GET,"+wmpAC+":"):
+		fn := strings.TrimPrefix(line, "GET,"+wmpAC+":")
+		if fn == "*" {
+			fns := make([]string, 0, len(w.values))
+			for fn := range w.values {
+				fns = append(fns, fn)
+			}
+			sort.Strings(fns)
+			replies := make([]string, 0, len(fns))
+			for _, fn := range fns {
+				replies = append(replies, chn(fn, w.values[fn]))
+			}
+			return replies
+		}
+		if v, ok := w.values[fn]; ok {
+			return []string{chn(fn, v)}
+		}
+		return []string{"ERR"}
+	case strings.HasPrefix(line, "SET,"+wmpAC+":"):
+		fn, value, ok := strings.Cut(strings.TrimPrefix(line, "SET,"+wmpAC+":"), ",")
+		if !ok || !w.allowed(fn, value) {
+			return []string{"ERR"}
+		}
+		w.values[fn] = value
+		fmt.Fprintf(conn, "ACK\r\n")
+		w.broadcast(chn(fn, value))
+		return nil
+	}
+	return []string{"ERR"}
+}
+
+// whether the unit accepts the value for the function
+func (w *WMPServer) allowed(fn, value string) bool {
+	l, ok := w.limits[fn]
+	if !ok {
+		return false
+	}
+	if fn == "SETPTEMP" {
+		v, err := strconv.Atoi(value)
+		min, _ := strconv.Atoi(l[0])
+		max, _ := strconv.Atoi(l[1])
+		return err == nil && v >= min && v <= max
+	}
+	for _, a := range l {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sends the line to every connection, callers must hold w.mu
+func (w *WMPServer) broadcast(line string) {
+	for conn := range w.conns {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+}
+
+func chn(fn, value string) string {
+	return fmt.Sprintf("CHN,%s:%s,%s", wmpAC, fn, value)
+}
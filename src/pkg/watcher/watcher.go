@@ -3,6 +3,8 @@ package watcher
 // TODO: split the package so that the web API is in another file
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"github.com/nullify005/service-intesis/pkg/intesishome"
 	"github.com/nullify005/service-intesis/pkg/metrics"
 	"github.com/nullify005/service-intesis/pkg/secrets"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -25,11 +28,6 @@ const (
 	DefaultMetricsPath string        = "/metrics"
 )
 
-// holds the internal state
-var (
-	watcher state
-)
-
 // the watcher polls the Intesis Home cloud API for changes in state
 // and exposes for Prometheus scraping
 type Watcher struct {
@@ -38,28 +36,46 @@ type Watcher struct {
 	hostname    string
 	username    string
 	password    string
-	device      int64
+	devices     []int64 // the devices to watch, every device when empty
 	healthPath  string
 	metricsPath string
 	verbose     bool
 	secrets     string
+	mappings    []string
+	client      []intesishome.Option
+	registry    *prometheus.Registry // the default registry when nil
+	state       *state               // shared by the copies New hands out
 }
 
-// internal state
+// internal state, the handlers & metrics close over the watcher's own
 type state struct {
-	ih        *intesishome.IntesisHome
-	devices   []intesishome.Device
-	status    map[string]interface{}
-	statusRaw map[string]interface{}
-	metrics   metrics.Metrics
-	mu        sync.Mutex
+	ih      *intesishome.IntesisHome
+	watched map[int64]bool                     // the devices being watched, every device when nil
+	devices map[int64]intesishome.DeviceStatus // the watched devices as of the last poll or push
+	order   []int64                            // the watched devices in the order they are configured
+	metrics *metrics.Collector
+	mu      sync.Mutex
 }
 
-// HVAC POST request
+// HVAC POST request, either a single param & value or a batch of commands
 type HVACRequest struct {
-	Device int64       `json:"device"`
-	Param  string      `json:"param" binding:"required"`
-	Value  interface{} `json:"value" binding:"required"`
+	Device   int64         `json:"device"`
+	Param    string        `json:"param,omitempty"`
+	Value    interface{}   `json:"value,omitempty"`
+	Commands []HVACCommand `json:"commands,omitempty"`
+}
+
+// a single param & value within a batch
+type HVACCommand struct {
+	Param string      `json:"param"`
+	Value interface{} `json:"value"`
+}
+
+// the outcome of each command within a batch
+type HVACResult struct {
+	HVACCommand
+	Ack   *intesishome.Ack `json:"ack,omitempty"`
+	Error string           `json:"error,omitempty"`
 }
 
 // HVAC GET response
@@ -119,18 +135,44 @@ func WithSecrets(s string) Option {
 	}
 }
 
-func New(user, pass string, device int64, opts ...Option) Watcher {
+// mapping files layered over the embedded uid & command mappings
+func WithMappings(paths ...string) Option {
+	return func(w *Watcher) {
+		w.mappings = append(w.mappings, paths...)
+	}
+}
+
+// only watch these devices rather than every device on the account
+func WithDevices(ids ...int64) Option {
+	return func(w *Watcher) {
+		w.devices = append(w.devices, ids...)
+	}
+}
+
+// the registry the metrics are registered with & served from, rather than the default one
+func WithRegistry(r *prometheus.Registry) Option {
+	return func(w *Watcher) {
+		w.registry = r
+	}
+}
+
+// further options for the Intesis Home client, such as its HTTP client or TCP proxy
+func WithClientOptions(opts ...intesishome.Option) Option {
+	return func(w *Watcher) {
+		w.client = append(w.client, opts...)
+	}
+}
+
+func New(user, pass string, opts ...Option) Watcher {
 	w := Watcher{
 		interval:    DefaultInterval,
 		listen:      DefaultListen,
 		username:    user,
 		password:    pass,
-		device:      device,
 		healthPath:  DefaultHealthPath,
 		metricsPath: DefaultMetricsPath,
 		verbose:     false,
 		secrets:     DefaultSecretsPath,
-		hostname:    intesishome.DefaultHostname,
 	}
 	for _, opt := range opts {
 		opt(&w)
@@ -144,18 +186,38 @@ func New(user, pass string, device int64, opts ...Option) Watcher {
 		w.username = s.Username
 		w.password = s.Password
 	}
-	watcher.ih = intesishome.New(
+	s := &state{ih: intesishome.New(
 		w.username, w.password,
-		intesishome.WithVerbose(w.verbose),
-		intesishome.WithHostname(w.hostname),
-	)
-	watcher.metrics = metrics.New()
-	if ok, err := watcher.ih.HasDevice(w.device); !ok {
-		p := "device not found"
-		if err != nil {
-			p = p + "\nerror: " + err.Error()
+		append([]intesishome.Option{
+			intesishome.WithVerbose(w.verbose),
+			intesishome.WithHostname(w.hostname),
+		}, append(w.client, intesishome.WithMappings(w.mappings...))...)...,
+	)}
+	w.state = s
+	// named after the client's mappings, which may carry uids of their own
+	metricsOpts := []metrics.Option{metrics.WithMappings(s.ih.Registry())}
+	if w.registry != nil {
+		metricsOpts = append(metricsOpts, metrics.WithRegisterer(w.registry))
+	}
+	m, err := metrics.New(s.watchedDevices, metricsOpts...)
+	if err != nil {
+		panic(fmt.Sprintf("unable to register metrics: %s", err.Error()))
+	}
+	// the metrics need the client's mappings, so it's observed once both exist
+	intesishome.WithObserver(m)(s.ih)
+	s.metrics = m
+	for _, id := range w.devices {
+		if ok, err := s.ih.HasDevice(id); !ok {
+			p := fmt.Sprintf("device not found: %v", id)
+			if err != nil {
+				p = p + "\nerror: " + err.Error()
+			}
+			panic(p)
+		}
+		if s.watched == nil {
+			s.watched = make(map[int64]bool)
 		}
-		panic(p)
+		s.watched[id] = true
 	}
 	return w
 }
@@ -164,97 +226,174 @@ func (w *Watcher) Watch() {
 	log.SetPrefix("service-intesis: ")
 	log.SetFlags(log.LstdFlags)
 	log.Printf("starting watcher")
-	log.Printf("device: %v", w.device)
+	if len(w.devices) > 0 {
+		log.Printf("devices: %v", w.devices)
+	} else {
+		log.Printf("devices: all")
+	}
 	log.Printf("interval: %v", w.interval)
 	log.Printf("listen: %s", w.listen)
 	watch(w)
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
-	router.GET(w.metricsPath, promHandler())
+	router.Use(w.state.metrics.Middleware())
+	router.GET(w.metricsPath, promHandler(w.registry))
 	router.GET(w.healthPath, healthHandler)
-	router.GET("/hvac/:device", hvacReadHandler)
-	router.POST("/hvac/:device", hvacWriteHandler)
+	router.GET("/hvac/:device", w.state.hvacReadHandler)
+	router.POST("/hvac/:device", w.state.hvacWriteHandler)
 	router.GET("/shutdown", shutdownHandler)
 	log.Fatal(router.Run(w.listen))
 }
 
 func watch(w *Watcher) {
-	var err error
+	s := w.state
 	// collect the startup info 1st before entering the loop
 	// if we can't bootstrap at ths point then we should panic
-	watcher.devices, err = watcher.ih.Devices()
-	if err != nil {
-		panic(err)
-	}
-	if err = refreshState(w.device); err != nil {
+	if err := s.refreshState(context.Background()); err != nil {
 		panic(err)
 	}
+	go subscribe(w)
 	go func() {
 		for {
-			var err error
 			time.Sleep(w.interval)
-			if err = refreshState(w.device); err != nil {
+			if err := s.refreshState(context.Background()); err != nil {
 				log.Printf("error refreshing state: %v", err.Error())
 				continue
 			}
-			log.Printf("(%v) power: %v mode: %v temp: %v setpoint: %v",
-				w.device, watcher.status["power"], watcher.status["mode"],
-				watcher.status["temperature"], watcher.status["setpoint"],
-			)
-			watcher.metrics.SetPoint(float64(watcher.statusRaw["setpoint"].(int) / 10))
-			watcher.metrics.Temperature(float64(watcher.statusRaw["temperature"].(int) / 10))
-			watcher.metrics.Power(float64(watcher.statusRaw["power"].(int)))
-			watcher.metrics.Mode(float64(watcher.statusRaw["mode"].(int)))
+			s.mu.Lock()
+			for _, id := range s.order {
+				status := s.devices[id].State.Values()
+				log.Printf("(%v) power: %v mode: %v temp: %v setpoint: %v",
+					id, status["power"], status["mode"],
+					status["temperature"], status["setpoint"],
+				)
+			}
+			s.mu.Unlock()
 		}
 	}()
 }
 
-func refreshState(device int64) (err error) {
-	watcher.mu.Lock()
-	defer watcher.mu.Unlock()
-	state, err := watcher.ih.Status(device)
+// applies the state pushed by the TCP gateway in between polls
+// without a subscription we simply fall back to polling
+func subscribe(w *Watcher) {
+	s := w.state
+	events, err := s.ih.Subscribe(context.Background())
+	if err != nil {
+		log.Printf("unable to subscribe to pushed state, relying on polling: %v", err.Error())
+		return
+	}
+	for ev := range events {
+		s.mu.Lock()
+		d, ok := s.devices[ev.DeviceID]
+		if ok {
+			if w.verbose {
+				log.Printf("(%v) pushed %s: %v", ev.DeviceID, ev.Name, ev.State)
+			}
+			d.State.Set(ev.Name, ev.Value)
+			s.devices[ev.DeviceID] = d
+		}
+		s.mu.Unlock()
+	}
+}
+
+// polls every device from a single control response & keeps those being watched
+// a watched device missing from the response keeps its last known state
+func (s *state) refreshState(ctx context.Context) (err error) {
+	snapshot, err := s.ih.StatusAllContext(ctx)
+	if s.metrics != nil {
+		s.metrics.ObservePoll(err)
+	}
 	if err != nil {
 		return
 	}
-	mapped := make(map[string]interface{})
-	for k, v := range state {
-		mV := intesishome.DecodeState(k, v.(int))
-		mapped[k] = mV
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.devices == nil {
+		s.devices = make(map[int64]intesishome.DeviceStatus)
+	}
+	for _, d := range snapshot.List() {
+		id, _ := strconv.ParseInt(d.Device.ID, 10, 64)
+		if s.watched != nil && !s.watched[id] {
+			continue
+		}
+		if _, ok := s.devices[id]; !ok {
+			s.order = append(s.order, id)
+		}
+		s.devices[id] = d
+	}
+	for id := range s.watched {
+		if _, ok := snapshot.Device(id); !ok {
+			log.Printf("(%v) missing from the control response, keeping its last known state", id)
+		}
 	}
-	watcher.statusRaw = state
-	watcher.status = mapped
 	return
 }
 
+// a copy of the watched devices in the order they are configured, for scraping
+func (s *state) watchedDevices() []intesishome.DeviceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	devices := make([]intesishome.DeviceStatus, 0, len(s.order))
+	for _, id := range s.order {
+		devices = append(devices, copyDevice(s.devices[id]))
+	}
+	return devices
+}
+
+// the device as of the last poll or push, copied so it can be used without the lock
+func (s *state) cachedDevice(device int64) (d intesishome.DeviceStatus, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok = s.devices[device]
+	return copyDevice(d), ok
+}
+
+// a copy of the device whose raw state pushes can't change underneath it
+func copyDevice(d intesishome.DeviceStatus) intesishome.DeviceStatus {
+	raw := make(map[string]int, len(d.State.Raw))
+	for name, v := range d.State.Raw {
+		raw[name] = v
+	}
+	d.State.Raw = raw
+	return d
+}
+
 func healthHandler(c *gin.Context) {
 	c.String(http.StatusOK, "ok")
 }
 
-func promHandler() gin.HandlerFunc {
+func promHandler(registry *prometheus.Registry) gin.HandlerFunc {
 	p := promhttp.Handler()
+	if registry != nil {
+		p = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
 	return func(c *gin.Context) {
 		p.ServeHTTP(c.Writer, c.Request)
 	}
 }
 
-// TODO: add in the device status in here too
-// should we actually append this to the Device struct?
-func hvacReadHandler(c *gin.Context) {
-	resp := HVACResponse{}
-	for _, d := range watcher.devices {
-		if c.Param("device") == d.ID {
-			resp.Device = d
-			resp.Status = watcher.status
-			c.JSON(http.StatusOK, resp)
-			return
-		}
+// responds with the device & its decoded state as of the last poll or push
+func (s *state) hvacReadHandler(c *gin.Context) {
+	device, err := strconv.ParseInt(c.Param("device"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	d, ok := s.devices[device]
+	resp := HVACResponse{Device: d.Device, Status: d.State.Values()}
+	s.mu.Unlock()
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no such device"})
+		return
 	}
-	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "no such device"})
+	c.JSON(http.StatusOK, resp)
 }
 
 // handles param set requests
-// try to conduct the set via the underlying API & then do a state refresh immediately after
-func hvacWriteHandler(c *gin.Context) {
+// validated against the device as of the last poll or push, so the cloud is only asked for
+// the set itself & then a state refresh immediately after
+func (s *state) hvacWriteHandler(c *gin.Context) {
 	var (
 		uid   int
 		value int
@@ -272,19 +411,118 @@ func hvacWriteHandler(c *gin.Context) {
 		return
 	}
 
-	uid, value, err = intesishome.MapCommand(request.Param, request.Value)
+	d, ok := s.cachedDevice(request.Device)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no such device"})
+		return
+	}
+
+	batch, err := request.batch()
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if batch {
+		s.hvacBatch(c, request, d)
+		return
+	}
 
-	if err = watcher.ih.Set(request.Device, uid, value); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// map via the device's own names & reject anything the unit doesn't support
+	// before it goes to the gateway
+	uid, value, err = d.MapCommand(request.Param, request.Value)
+	if err != nil {
+		c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// abandon the set if the client goes away
+	if err = s.ih.SetContext(c.Request.Context(), request.Device, uid, value); err != nil {
+		c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusAccepted, request)
-	_ = refreshState(request.Device)
+	_ = s.refreshState(c.Request.Context())
+}
+
+// whether the request is a batch, it must be either a batch or a single param & value
+func (r HVACRequest) batch() (bool, error) {
+	switch {
+	case len(r.Commands) > 0 && (r.Param != "" || r.Value != nil):
+		return false, errors.New("either param & value or commands, not both")
+	case len(r.Commands) > 0:
+		for i, cmd := range r.Commands {
+			if cmd.Param == "" || cmd.Value == nil {
+				return false, fmt.Errorf("command: %v requires a param & value", i)
+			}
+		}
+		return true, nil
+	case r.Param == "" || r.Value == nil:
+		return false, errors.New("param & value or commands are required")
+	}
+	return false, nil
+}
+
+// maps every command in the batch via the device as of the last poll or push before setting
+// any, then sets them in order stopping at the first failure. responds with the result of each command
+func (s *state) hvacBatch(c *gin.Context, request HVACRequest, d intesishome.DeviceStatus) {
+	var err error
+	ctx := c.Request.Context()
+	cmds := make([]intesishome.Command, len(request.Commands))
+	for i, cmd := range request.Commands {
+		if cmds[i].Uid, cmds[i].Value, err = d.MapCommand(cmd.Param, cmd.Value); err != nil {
+			c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": fmt.Sprintf("command: %v %s", i, err.Error())})
+			return
+		}
+	}
+
+	sets, err := s.ih.SetManyContext(ctx, request.Device, cmds)
+	results := make([]HVACResult, len(request.Commands))
+	for i, cmd := range request.Commands {
+		results[i].HVACCommand = cmd
+		if i >= len(sets) {
+			// the batch never started
+			results[i].Error = intesishome.ErrNotSent.Error()
+			continue
+		}
+		if sets[i].Err != nil {
+			results[i].Error = sets[i].Err.Error()
+			continue
+		}
+		ack := sets[i].Ack
+		results[i].Ack = &ack
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": err.Error(), "device": request.Device, "results": results})
+	} else {
+		c.JSON(http.StatusAccepted, gin.H{"device": request.Device, "results": results})
+	}
+	_ = s.refreshState(ctx)
+}
+
+// maps a failure from the Intesis Home client onto an HTTP status
+func errorStatus(err error) int {
+	var (
+		cloudErr *intesishome.CloudError
+		localErr *intesishome.LocalError
+	)
+	switch {
+	case errors.Is(err, intesishome.ErrInvalidCommand):
+		return http.StatusBadRequest
+	case errors.Is(err, intesishome.ErrDeviceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, intesishome.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, intesishome.ErrUnreachable),
+		errors.Is(err, intesishome.ErrTokenRejected),
+		errors.Is(err, intesishome.ErrProtocol),
+		errors.As(err, &cloudErr),
+		errors.As(err, &localErr):
+		return http.StatusBadGateway
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
 // signals the watcher that is should shutdown the observation loop & quit
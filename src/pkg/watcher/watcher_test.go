@@ -2,3 +2,253 @@ package watcher
 
 // TODO: end to end tests
 // TODO: api specific tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nullify005/service-intesis/pkg/intesishome"
+	"github.com/nullify005/service-intesis/pkg/metrics"
+	"github.com/nullify005/service-intesis/pkg/mock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"invalid command", fmt.Errorf("wrapped: %w", intesishome.ErrInvalidCommand), http.StatusBadRequest},
+		{"device not found", fmt.Errorf("wrapped: %w", intesishome.ErrDeviceNotFound), http.StatusNotFound},
+		{"timeout", intesishome.ErrTimeout, http.StatusGatewayTimeout},
+		{"unreachable", intesishome.ErrUnreachable, http.StatusBadGateway},
+		{"token rejected", intesishome.ErrTokenRejected, http.StatusBadGateway},
+		{"cloud error", &intesishome.CloudError{Code: 1}, http.StatusBadGateway},
+		{"local error", &intesishome.LocalError{Code: 3}, http.StatusBadGateway},
+		{"cancelled", context.Canceled, http.StatusServiceUnavailable},
+		{"anything else", errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, errorStatus(tt.err))
+		})
+	}
+}
+
+func TestHVACRequestBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		request HVACRequest
+		batch   bool
+		err     string
+	}{
+		{"single", HVACRequest{Param: "mode", Value: "cool"}, false, ""},
+		{"batch", HVACRequest{Commands: []HVACCommand{{"mode", "cool"}, {"setpoint", 21.5}}}, true, ""},
+		{"neither", HVACRequest{}, false, "param & value or commands are required"},
+		{"param without value", HVACRequest{Param: "mode"}, false, "param & value or commands are required"},
+		{"both", HVACRequest{Param: "mode", Value: "cool", Commands: []HVACCommand{{"power", "on"}}}, false, "not both"},
+		{"incomplete command", HVACRequest{Commands: []HVACCommand{{"mode", "cool"}, {"setpoint", nil}}}, false, "command: 1 requires a param & value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := tt.request.batch()
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.batch, batch)
+		})
+	}
+}
+
+func TestHVACReadHandler(t *testing.T) {
+	payload, err := os.ReadFile("../intesishome/assets/tests/multiDeviceControlResponse.json")
+	if err != nil {
+		t.Fatalf("unable to read the payload: %v", err.Error())
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer s.Close()
+	registry := prometheus.NewRegistry()
+	watcher := &state{watched: map[int64]bool{127934703953: true, 227934703955: true}}
+	m, err := metrics.New(watcher.watchedDevices, metrics.WithRegisterer(registry))
+	assert.NoError(t, err)
+	watcher.ih = intesishome.New("u", "p", intesishome.WithHostname(s.URL), intesishome.WithObserver(m))
+	watcher.metrics = m
+	assert.NoError(t, watcher.refreshState(context.Background()))
+	assert.Equal(t, []int64{127934703953, 227934703955}, watcher.order)
+	// another watcher's state is its own
+	assert.Empty(t, (&state{}).watchedDevices())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(m.Middleware())
+	router.GET("/hvac/:device", watcher.hvacReadHandler)
+	read := func(device string) (int, HVACResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hvac/"+device, nil))
+		var resp HVACResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	t.Run("each device has its own state", func(t *testing.T) {
+		code, resp := read("127934703953")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "Lounge", resp.Device.Name)
+		assert.Equal(t, "on", resp.Status["power"])
+		assert.Equal(t, 21.5, resp.Status["temperature"])
+
+		code, resp = read("227934703955")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "Server Room", resp.Device.Name)
+		assert.Equal(t, "cool", resp.Status["mode"])
+		assert.NotContains(t, resp.Status, "temperature")
+	})
+	t.Run("unwatched devices aren't served", func(t *testing.T) {
+		code, _ := read("127934703954")
+		assert.Equal(t, http.StatusNotFound, code)
+		_, ok := watcher.cachedDevice(127934703954)
+		assert.False(t, ok)
+	})
+	t.Run("malformed device", func(t *testing.T) {
+		code, _ := read("lounge")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+	t.Run("metrics for each watched device", func(t *testing.T) {
+		router.GET(DefaultMetricsPath, promHandler(registry))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DefaultMetricsPath, nil))
+		assert.Contains(t, rec.Body.String(), `hvac_temperature_celsius{device_id="127934703953",device_name="Lounge",installation="Home"} 21.5`)
+		assert.Contains(t, rec.Body.String(), `hvac_mode{device_id="227934703955",device_name="Server Room",installation="Office",mode="cool"} 1`)
+		assert.NotContains(t, rec.Body.String(), "Bedroom")
+		assert.Contains(t, rec.Body.String(), "hvac_polls_total 1")
+		assert.Contains(t, rec.Body.String(), `hvac_cloud_request_duration_seconds_count{outcome="ok"} 1`)
+		assert.Contains(t, rec.Body.String(), `hvac_api_request_duration_seconds_count{method="GET",route="/hvac/:device",status="404"} 1`)
+	})
+}
+
+// a local API mock which counts the status reads & fails sets of the uids in fail
+func testLocalBackend(t *testing.T, fail ...int) (*state, *int32) {
+	m := mock.NewLocalServer()
+	reads := new(int32)
+	h := m.Handler()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var req struct {
+			Command string `json:"command"`
+			Data    struct {
+				Uid int `json:"uid"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(body, &req)
+		switch {
+		case req.Command == "getdatapointvalue":
+			atomic.AddInt32(reads, 1)
+		case req.Command == "setdatapointvalue" && contains(fail, req.Data.Uid):
+			w.Write([]byte(`{"success":false,"error":{"code":4,"message":"invalid value"}}`))
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	ih := intesishome.New(mock.DefaultLocalUsername, mock.DefaultLocalPassword, intesishome.WithLocal(s.URL),
+		intesishome.WithSetDelay(0), intesishome.WithRetryPolicy(intesishome.RetryPolicy{MaxAttempts: 1}))
+	t.Cleanup(ih.Close)
+	st := &state{ih: ih}
+	assert.NoError(t, st.refreshState(context.Background()))
+	atomic.StoreInt32(reads, 0)
+	return st, reads
+}
+
+func contains(uids []int, uid int) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHVACWriteHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	post := func(s *state, device, body string) (int, map[string]interface{}) {
+		router := gin.New()
+		router.POST("/hvac/:device", s.hvacWriteHandler)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/hvac/"+device, strings.NewReader(body)))
+		var resp map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+	d := mock.DefaultLocalSN
+
+	t.Run("valid write", func(t *testing.T) {
+		s, reads := testLocalBackend(t)
+		code, _ := post(s, d, `{"param": "setpoint", "value": 22.5}`)
+		assert.Equal(t, http.StatusAccepted, code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(reads), "only the refresh after the set reads the status")
+		ds, _ := s.cachedDevice(127934703953)
+		assert.Equal(t, 22.5, *ds.State.Setpoint)
+	})
+	t.Run("unknown param", func(t *testing.T) {
+		s, reads := testLocalBackend(t)
+		code, resp := post(s, d, `{"param": "bogus", "value": 1}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Contains(t, resp["error"], "bogus")
+		assert.Zero(t, atomic.LoadInt32(reads))
+	})
+	t.Run("out of range value", func(t *testing.T) {
+		s, reads := testLocalBackend(t)
+		code, resp := post(s, d, `{"param": "setpoint", "value": 45}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Contains(t, resp["error"], "above the")
+		assert.Zero(t, atomic.LoadInt32(reads))
+	})
+	t.Run("unknown device", func(t *testing.T) {
+		s, _ := testLocalBackend(t)
+		code, _ := post(s, "1", `{"param": "power", "value": "off"}`)
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+	t.Run("backend error", func(t *testing.T) {
+		s, _ := testLocalBackend(t, 1)
+		code, resp := post(s, d, `{"param": "power", "value": "off"}`)
+		assert.Equal(t, http.StatusBadGateway, code)
+		assert.Contains(t, resp["error"], "invalid value")
+	})
+	t.Run("batch with a partial failure", func(t *testing.T) {
+		s, reads := testLocalBackend(t, 2)
+		code, resp := post(s, d, `{"commands": [{"param": "power", "value": "off"}, {"param": "mode", "value": "cool"}, {"param": "setpoint", "value": 22}]}`)
+		assert.Equal(t, http.StatusBadGateway, code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(reads), "only the refresh after the batch reads the status")
+		results, _ := resp["results"].([]interface{})
+		if assert.Len(t, results, 3) {
+			assert.NotNil(t, results[0].(map[string]interface{})["ack"])
+			assert.Contains(t, results[1].(map[string]interface{})["error"], "invalid value")
+			assert.Equal(t, intesishome.ErrNotSent.Error(), results[2].(map[string]interface{})["error"])
+		}
+	})
+	t.Run("batch with an invalid command sends nothing", func(t *testing.T) {
+		s, reads := testLocalBackend(t)
+		code, resp := post(s, d, `{"commands": [{"param": "power", "value": "off"}, {"param": "setpoint", "value": 45}]}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Contains(t, resp["error"], "command: 1")
+		assert.Zero(t, atomic.LoadInt32(reads))
+	})
+}